@@ -0,0 +1,68 @@
+/*
+ *  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+// Package awssdkv2 builds the SigV4 authenticator from an AWS SDK for Go v2 config. it is a
+// separate module, so applications on the v1 SDK don't depend on v2.
+package awssdkv2
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sigv4-auth-cassandra-gocql-driver-plugin/sigv4"
+)
+
+// adapts an AWS SDK for Go v2 credentials provider to a sigv4.CredentialProvider
+type credentialsProvider struct {
+	provider aws.CredentialsProvider
+}
+
+func (p credentialsProvider) Retrieve(ctx context.Context) (sigv4.SigV4Credentials, error) {
+	creds, err := p.provider.Retrieve(ctx)
+	if err != nil {
+		return sigv4.SigV4Credentials{}, err
+	}
+
+	// values read from files or environment variables commonly carry a trailing newline,
+	// trimmed as by the v1 constructors
+	credentials := sigv4.SigV4Credentials{
+		AccessKeyId:     strings.TrimSpace(creds.AccessKeyID),
+		SecretAccessKey: strings.TrimSpace(creds.SecretAccessKey),
+		SessionToken:    strings.TrimSpace(creds.SessionToken)}
+	if creds.CanExpire {
+		credentials.Expiration = creds.Expires
+	}
+	return credentials, nil
+}
+
+// initializes authenticator with the region and credentials provider of an AWS SDK for Go v2
+// config, e.g. one returned by config.LoadDefaultConfig. the authenticator is validated as by
+// AwsAuthenticator.Validate, which retrieves credentials once to surface configuration errors
+// early. challenges then retrieve them through the provider, which is expected to do its own
+// caching (config.LoadDefaultConfig wraps it in aws.CredentialsCache).
+func NewAwsAuthenticator(cfg aws.Config, opts ...sigv4.Option) (sigv4.AwsAuthenticator, error) {
+	if cfg.Credentials == nil {
+		return sigv4.AwsAuthenticator{}, errors.New("aws.Config has no credentials provider")
+	}
+
+	auth := sigv4.NewAwsAuthenticatorWithCredentialProvider(cfg.Region, credentialsProvider{cfg.Credentials}, opts...)
+	if err := auth.Validate(); err != nil {
+		return sigv4.AwsAuthenticator{}, err
+	}
+	return auth, nil
+}
@@ -0,0 +1,103 @@
+/*
+ *  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package awssdkv2
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sigv4-auth-cassandra-gocql-driver-plugin/sigv4"
+	"github.com/stretchr/testify/assert"
+)
+
+var stdNonce = []byte("nonce=91703fdc2ef562e19fbdab0f58e42fe5")
+
+func stdClock() time.Time {
+	t, _ := time.Parse(time.RFC3339, "2020-06-09T22:41:51Z")
+	return t
+}
+
+func staticConfig(accessKeyId string, secretAccessKey string) aws.Config {
+	return aws.Config{
+		Region: "us-west-2",
+		Credentials: aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+			return aws.Credentials{
+				AccessKeyID:     accessKeyId,
+				SecretAccessKey: secretAccessKey,
+			}, nil
+		}),
+	}
+}
+
+func TestNewAwsAuthenticator(t *testing.T) {
+	target, err := NewAwsAuthenticator(staticConfig("UserID-1", "UserSecretKey-1"), sigv4.WithClock(stdClock))
+	assert.NoError(t, err)
+	assert.Equal(t, "us-west-2", target.Region)
+
+	_, challenger, _ := target.Challenge(nil)
+
+	resp, _, _ := challenger.Challenge(stdNonce)
+	expected := "signature=7f3691c18a81b8ce7457699effbfae5b09b4e0714ab38c1292dbdf082c9ddd87,access_key=UserID-1,amzdate=2020-06-09T22:41:51.000Z"
+	assert.Equal(t, expected, string(resp))
+}
+
+func TestNewAwsAuthenticatorTrimsCredentials(t *testing.T) {
+	target, err := NewAwsAuthenticator(staticConfig("UserID-1\n", " UserSecretKey-1\n"), sigv4.WithClock(stdClock))
+	assert.NoError(t, err)
+
+	_, challenger, _ := target.Challenge(nil)
+
+	resp, _, _ := challenger.Challenge(stdNonce)
+	expected := "signature=7f3691c18a81b8ce7457699effbfae5b09b4e0714ab38c1292dbdf082c9ddd87,access_key=UserID-1,amzdate=2020-06-09T22:41:51.000Z"
+	assert.Equal(t, expected, string(resp))
+}
+
+func TestNewAwsAuthenticatorExpiration(t *testing.T) {
+	expires, _ := time.Parse(time.RFC3339, "2020-06-09T23:41:51Z")
+	provider := credentialsProvider{aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+		return aws.Credentials{AccessKeyID: "UserID-1", CanExpire: true, Expires: expires}, nil
+	})}
+
+	credentials, err := provider.Retrieve(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, expires, credentials.Expiration)
+}
+
+func TestNewAwsAuthenticatorErrors(t *testing.T) {
+	_, err := NewAwsAuthenticator(aws.Config{Region: "us-west-2"})
+	assert.EqualError(t, err, "aws.Config has no credentials provider")
+
+	cfg := aws.Config{
+		Region: "us-west-2",
+		Credentials: aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+			return aws.Credentials{}, errors.New("no credentials")
+		}),
+	}
+	_, err = NewAwsAuthenticator(cfg)
+	assert.EqualError(t, err, "failed to retrieve AWS credentials: no credentials")
+
+	_, err = NewAwsAuthenticator(staticConfig("UserID-1", ""))
+	assert.EqualError(t, err, "AWS secret access key is empty")
+
+	cfg = staticConfig("UserID-1", "UserSecretKey-1")
+	cfg.Region = ""
+	_, err = NewAwsAuthenticator(cfg)
+	assert.True(t, errors.Is(err, sigv4.ErrRegionNotConfigured))
+}
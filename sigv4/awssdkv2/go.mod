@@ -0,0 +1,13 @@
+module github.com/aws/aws-sigv4-auth-cassandra-gocql-driver-plugin/sigv4/awssdkv2
+
+go 1.14
+
+require (
+	github.com/aws/aws-sdk-go-v2 v1.21.0
+	github.com/aws/aws-sigv4-auth-cassandra-gocql-driver-plugin v1.1.0
+	github.com/stretchr/testify v1.6.1
+)
+
+// builds against the working tree until the root module is released with the APIs used here.
+// removed at release, when the require above is set to that version.
+replace github.com/aws/aws-sigv4-auth-cassandra-gocql-driver-plugin => ../..
@@ -0,0 +1,244 @@
+/*
+ *  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package sigv4
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// how long before expiration cached credentials are refreshed by default
+const defaultRefreshAhead = ExpiryMargin
+
+// how long WithLastKnownGoodOnError serves the cached credentials after a failed refresh
+// before retrying the source
+const lastKnownGoodBackoff = 30 * time.Second
+
+// Option applied to the provider returned by NewCachingCredentialProvider
+type CachingOption func(provider *cachingProvider)
+
+// refreshes a random extra amount of time, up to max, ahead of expiration.
+// instances started together then spread out their refreshes instead of all hitting
+// the credential source (e.g. STS) at the same moment. max is clamped to half the time
+// left until the refresh, reported to the logger set by WithCacheLogger.
+func WithRefreshJitter(max time.Duration) CachingOption {
+	return func(provider *cachingProvider) {
+		provider.maxJitter = max
+	}
+}
+
+// refreshes credentials window ahead of their expiration instead of ExpiryMargin, the margin
+// within which SigV4Credentials.IsExpired reports them as expired, e.g. a longer window for
+// slow credential sources, so credentials never expire mid-handshake. a window that is not
+// positive falls back to ExpiryMargin. the lifetime of credentials is only known once they are
+// retrieved: a window not shorter than it is clamped to half the lifetime, so they are still
+// reused for a while rather than retrieved again on every challenge. both are reported to the
+// logger set by WithCacheLogger.
+func WithRefreshAhead(window time.Duration) CachingOption {
+	return func(provider *cachingProvider) {
+		provider.refreshAhead = window
+	}
+}
+
+// receives the caching provider's warnings, e.g. a refresh ahead window or jitter that was clamped
+func WithCacheLogger(logger Logger) CachingOption {
+	return func(provider *cachingProvider) {
+		provider.logger = logger
+	}
+}
+
+// also refreshes credentials once they have been cached for ttl, even if they expire later or
+// have no expiration at all. useful for sources such as STS wrappers that don't report expiry.
+func WithCacheTTL(ttl time.Duration) CachingOption {
+	return func(provider *cachingProvider) {
+		provider.ttl = ttl
+	}
+}
+
+// keeps serving the last retrieved credentials when a refresh fails, as long as they have
+// not expired yet, and retries the source at most every 30 seconds meanwhile. the error is
+// only returned once no still-valid credentials are cached, so a brief outage of the
+// credential source does not fail new connections.
+func WithLastKnownGoodOnError() CachingOption {
+	return func(provider *cachingProvider) {
+		provider.lastKnownGood = true
+	}
+}
+
+type cachingProvider struct {
+	inner         CredentialProvider
+	ttl           time.Duration
+	refreshAhead  time.Duration
+	maxJitter     time.Duration
+	lastKnownGood bool
+	logger        Logger
+	now           func() time.Time    // replaced in tests
+	randInt63     func(n int64) int64 // replaced in tests
+
+	mu          sync.Mutex
+	cached      bool
+	credentials SigV4Credentials
+	refreshAt   time.Time    // zero when the cached credentials never expire
+	refreshing  *refreshCall // the in-flight refresh concurrent challenges wait for, if any
+}
+
+// a refresh shared by the challenges that need credentials while it runs
+type refreshCall struct {
+	done        chan struct{} // closed once credentials and err are set
+	credentials SigV4Credentials
+	err         error
+}
+
+// wraps a provider so credentials are retrieved once and reused until they approach
+// their Expiration. credentials without an expiration are cached indefinitely unless
+// WithCacheTTL is used.
+// the returned provider is safe for concurrent use.
+func NewCachingCredentialProvider(inner CredentialProvider, opts ...CachingOption) CredentialProvider {
+	provider := &cachingProvider{
+		inner:        inner,
+		refreshAhead: defaultRefreshAhead,
+		now:          time.Now,
+		randInt63:    rand.Int63n}
+	for _, opt := range opts {
+		opt(provider)
+	}
+	if provider.refreshAhead <= 0 {
+		if provider.logger != nil {
+			provider.logger.Debugf("sigv4: refresh ahead window %s is not positive, using %s",
+				provider.refreshAhead, defaultRefreshAhead)
+		}
+		provider.refreshAhead = defaultRefreshAhead
+	}
+	return provider
+}
+
+// the inner provider is called without holding the lock, and challenges waiting for a refresh
+// started by another one still give up when their own ctx is done, so a slow credential source
+// fails each challenge after its CredentialsTimeout rather than blocking all of them.
+func (p *cachingProvider) Retrieve(ctx context.Context) (SigV4Credentials, error) {
+	for {
+		p.mu.Lock()
+		if p.cached && (p.refreshAt.IsZero() || p.now().Before(p.refreshAt)) {
+			credentials := p.credentials
+			p.mu.Unlock()
+			return credentials, nil
+		}
+		if call := p.refreshing; call != nil {
+			p.mu.Unlock()
+			select {
+			case <-call.done:
+			case <-ctx.Done():
+				return SigV4Credentials{}, ctx.Err()
+			}
+			// the challenge that started the refresh ran out of time, retry within this one's
+			if isContextError(call.err) && ctx.Err() == nil {
+				continue
+			}
+			return call.credentials, call.err
+		}
+		call := &refreshCall{done: make(chan struct{})}
+		p.refreshing = call
+		p.mu.Unlock()
+
+		call.credentials, call.err = p.refresh(ctx)
+		close(call.done)
+		return call.credentials, call.err
+	}
+}
+
+// retrieves credentials from the inner provider and caches them, or falls back to the last
+// known good ones. ends the in-flight refresh.
+func (p *cachingProvider) refresh(ctx context.Context) (SigV4Credentials, error) {
+	credentials, err := p.inner.Retrieve(ctx)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.refreshing = nil
+	if err != nil {
+		if p.lastKnownGood && p.cached && !p.credentials.expiresWithin(p.now(), 0) {
+			// back off, so challenges keep getting the cached credentials without each one
+			// waiting on the failing source
+			p.refreshAt = p.now().Add(lastKnownGoodBackoff)
+			if expiration := p.credentials.Expiration; !expiration.IsZero() && expiration.Before(p.refreshAt) {
+				p.refreshAt = expiration
+			}
+			return p.credentials, nil
+		}
+		return SigV4Credentials{}, err
+	}
+
+	p.cached = true
+	p.credentials = credentials
+	p.refreshAt = p.refreshTime(credentials)
+	return credentials, nil
+}
+
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// computes when the given, just retrieved, credentials should next be refreshed
+func (p *cachingProvider) refreshTime(credentials SigV4Credentials) time.Time {
+	var refreshAt time.Time
+	if !credentials.Expiration.IsZero() {
+		refreshAhead := p.refreshAhead
+		if lifetime := credentials.Expiration.Sub(p.now()); refreshAhead >= lifetime {
+			refreshAhead = lifetime / 2
+			if p.logger != nil {
+				p.logger.Debugf("sigv4: refresh ahead window %s is not shorter than the %s lifetime of access key %s, "+
+					"clamped to %s", p.refreshAhead, lifetime, credentials.AccessKeyId, refreshAhead)
+			}
+		}
+		refreshAt = credentials.Expiration.Add(-refreshAhead)
+	}
+	if p.ttl > 0 {
+		if expiresAt := p.now().Add(p.ttl); refreshAt.IsZero() || expiresAt.Before(refreshAt) {
+			refreshAt = expiresAt
+		}
+	}
+
+	if !refreshAt.IsZero() && p.maxJitter > 0 {
+		// jitter at most half the time left, so it cannot move the refresh before now
+		maxJitter := p.maxJitter
+		if remaining := refreshAt.Sub(p.now()); maxJitter > remaining/2 {
+			maxJitter = remaining / 2
+			if p.logger != nil {
+				p.logger.Debugf("sigv4: refresh jitter %s is longer than half the %s until access key %s is refreshed, "+
+					"clamped to %s", p.maxJitter, remaining, credentials.AccessKeyId, maxJitter)
+			}
+		}
+		if maxJitter > 0 {
+			refreshAt = refreshAt.Add(-time.Duration(p.randInt63(int64(maxJitter))))
+		}
+	}
+	return refreshAt
+}
+
+// wraps a callback so its credentials are reused for ttl, or until they approach their
+// Expiration if that is sooner. opts are applied after the ttl, e.g. WithRefreshJitter to
+// spread the refreshes of many instances or WithRefreshAhead to start refreshing earlier.
+// the returned callback is safe for concurrent use by multiple gocql connections, which share
+// a single in-flight refresh.
+func NewCachingCredentialsCallback(inner SigV4CredentialsCallback, ttl time.Duration, opts ...CachingOption) SigV4CredentialsCallback {
+	provider := NewCachingCredentialProvider(inner, append([]CachingOption{WithCacheTTL(ttl)}, opts...)...)
+	return func() (SigV4Credentials, error) {
+		return provider.Retrieve(context.Background())
+	}
+}
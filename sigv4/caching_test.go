@@ -0,0 +1,399 @@
+/*
+ *  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package sigv4
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// returns credentials expiring an hour after the fake clock and counts retrievals
+type countingProvider struct {
+	calls int
+	now   *time.Time
+	err   error
+}
+
+func (p *countingProvider) Retrieve(ctx context.Context) (SigV4Credentials, error) {
+	p.calls++
+	if p.err != nil {
+		return SigV4Credentials{}, p.err
+	}
+	return SigV4Credentials{
+		AccessKeyId:     "UserID-1",
+		SecretAccessKey: "UserSecretKey-1",
+		Expiration:      p.now.Add(time.Hour),
+	}, nil
+}
+
+func buildCachingTarget(opts ...CachingOption) (*cachingProvider, *countingProvider, *time.Time) {
+	now, _ := time.Parse(time.RFC3339, "2020-06-09T22:41:51Z")
+	inner := &countingProvider{now: &now}
+	provider := NewCachingCredentialProvider(inner, opts...).(*cachingProvider)
+	provider.now = func() time.Time { return now }
+	return provider, inner, &now
+}
+
+func TestCachingProviderReusesCredentials(t *testing.T) {
+	provider, inner, now := buildCachingTarget()
+
+	provider.Retrieve(context.Background())
+	*now = now.Add(54 * time.Minute)
+	credentials, err := provider.Retrieve(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, "UserID-1", credentials.AccessKeyId)
+	assert.Equal(t, 1, inner.calls)
+}
+
+func TestCachingProviderRefreshesAheadOfExpiration(t *testing.T) {
+	provider, inner, now := buildCachingTarget()
+
+	provider.Retrieve(context.Background())
+	*now = now.Add(55 * time.Minute)
+	provider.Retrieve(context.Background())
+
+	assert.Equal(t, 2, inner.calls)
+}
+
+func TestCachingProviderRefreshAhead(t *testing.T) {
+	provider, inner, now := buildCachingTarget(WithRefreshAhead(15 * time.Minute))
+
+	provider.Retrieve(context.Background())
+	*now = now.Add(44*time.Minute + 59*time.Second)
+	provider.Retrieve(context.Background())
+	assert.Equal(t, 1, inner.calls)
+
+	*now = now.Add(time.Second)
+	provider.Retrieve(context.Background())
+	assert.Equal(t, 2, inner.calls)
+}
+
+func TestCachingProviderRefreshAheadNonPositiveWindow(t *testing.T) {
+	for _, window := range []time.Duration{0, -time.Minute} {
+		logger := &recordingLogger{}
+		provider, inner, now := buildCachingTarget(WithRefreshAhead(window), WithCacheLogger(logger))
+		assert.Equal(t, ExpiryMargin, provider.refreshAhead)
+		assert.Equal(t, []string{"sigv4: refresh ahead window " + window.String() + " is not positive, using 5m0s"}, logger.lines)
+
+		provider.Retrieve(context.Background())
+		*now = now.Add(55 * time.Minute)
+		provider.Retrieve(context.Background())
+		assert.Equal(t, 2, inner.calls)
+	}
+}
+
+func TestCachingProviderRefreshAheadLongerThanLifetime(t *testing.T) {
+	// the credentials live for an hour
+	logger := &recordingLogger{}
+	provider, inner, now := buildCachingTarget(WithRefreshAhead(2*time.Hour), WithCacheLogger(logger))
+
+	provider.Retrieve(context.Background())
+	*now = now.Add(29 * time.Minute)
+	provider.Retrieve(context.Background())
+	assert.Equal(t, 1, inner.calls)
+	assert.Equal(t, []string{"sigv4: refresh ahead window 2h0m0s is not shorter than the 1h0m0s lifetime of access key UserID-1, clamped to 30m0s"}, logger.lines)
+
+	*now = now.Add(time.Minute)
+	provider.Retrieve(context.Background())
+	assert.Equal(t, 2, inner.calls)
+}
+
+func TestCachingProviderRefreshesOnceCredentialsReportExpired(t *testing.T) {
+	provider, inner, now := buildCachingTarget()
+
+	credentials, _ := provider.Retrieve(context.Background())
+	*now = credentials.Expiration.Add(-ExpiryMargin - time.Nanosecond)
+	provider.Retrieve(context.Background())
+	assert.False(t, credentials.IsExpired(*now))
+	assert.Equal(t, 1, inner.calls)
+
+	*now = now.Add(time.Nanosecond)
+	provider.Retrieve(context.Background())
+	assert.True(t, credentials.IsExpired(*now))
+	assert.Equal(t, 2, inner.calls)
+}
+
+func TestCachingProviderNeverRefreshesNonExpiringCredentials(t *testing.T) {
+	calls := 0
+	callback := SigV4CredentialsCallback(func() (SigV4Credentials, error) {
+		calls++
+		return SigV4Credentials{AccessKeyId: "UserID-1"}, nil
+	})
+	provider := NewCachingCredentialProvider(callback)
+
+	provider.Retrieve(context.Background())
+	provider.Retrieve(context.Background())
+
+	assert.Equal(t, 1, calls)
+}
+
+func TestCachingProviderDoesNotCacheErrors(t *testing.T) {
+	provider, inner, _ := buildCachingTarget()
+	inner.err = errors.New("throttled")
+
+	_, err := provider.Retrieve(context.Background())
+	assert.EqualError(t, err, "throttled")
+
+	inner.err = nil
+	_, err = provider.Retrieve(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, inner.calls)
+}
+
+func TestCachingProviderRefreshJitter(t *testing.T) {
+	provider, inner, now := buildCachingTarget(WithRefreshJitter(10 * time.Minute))
+	provider.randInt63 = func(n int64) int64 {
+		assert.Equal(t, int64(10*time.Minute), n)
+		return int64(3 * time.Minute)
+	}
+
+	provider.Retrieve(context.Background())
+	*now = now.Add(51*time.Minute + 59*time.Second)
+	provider.Retrieve(context.Background())
+	assert.Equal(t, 1, inner.calls)
+
+	*now = now.Add(time.Second)
+	provider.Retrieve(context.Background())
+	assert.Equal(t, 2, inner.calls)
+}
+
+func TestCachingProviderRefreshJitterLongerThanLifetime(t *testing.T) {
+	// the credentials live for an hour and are refreshed 55 minutes in without jitter
+	logger := &recordingLogger{}
+	provider, inner, now := buildCachingTarget(WithRefreshJitter(2*time.Hour), WithCacheLogger(logger))
+	provider.randInt63 = func(n int64) int64 {
+		assert.Equal(t, int64(27*time.Minute+30*time.Second), n)
+		return n - 1
+	}
+
+	for i := 0; i < 100; i++ {
+		provider.Retrieve(context.Background())
+		*now = now.Add(16 * time.Second)
+	}
+	assert.Equal(t, 1, inner.calls)
+	assert.Equal(t, []string{"sigv4: refresh jitter 2h0m0s is longer than half the 55m0s until access key UserID-1 is refreshed, clamped to 27m30s"}, logger.lines)
+
+	*now = now.Add(time.Minute)
+	provider.Retrieve(context.Background())
+	assert.Equal(t, 2, inner.calls)
+}
+
+func TestLastKnownGoodWhenRefreshFailsAndCacheValid(t *testing.T) {
+	provider, inner, now := buildCachingTarget(WithLastKnownGoodOnError())
+
+	provider.Retrieve(context.Background())
+	*now = now.Add(59 * time.Minute)
+	inner.err = errors.New("throttled")
+	credentials, err := provider.Retrieve(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, "UserID-1", credentials.AccessKeyId)
+	assert.Equal(t, 2, inner.calls)
+}
+
+func TestLastKnownGoodBacksOffWhileRefreshFails(t *testing.T) {
+	provider, inner, now := buildCachingTarget(WithLastKnownGoodOnError())
+
+	provider.Retrieve(context.Background())
+	*now = now.Add(56 * time.Minute)
+	inner.err = errors.New("throttled")
+	for i := 0; i < 5; i++ {
+		credentials, err := provider.Retrieve(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, "UserID-1", credentials.AccessKeyId)
+	}
+	assert.Equal(t, 2, inner.calls)
+
+	*now = now.Add(lastKnownGoodBackoff)
+	provider.Retrieve(context.Background())
+	provider.Retrieve(context.Background())
+	assert.Equal(t, 3, inner.calls)
+
+	// the retry is not pushed past the expiration of the cached credentials
+	*now = now.Add(3*time.Minute + 20*time.Second)
+	_, err := provider.Retrieve(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 4, inner.calls)
+	*now = now.Add(10 * time.Second)
+	_, err = provider.Retrieve(context.Background())
+	assert.EqualError(t, err, "throttled")
+	assert.Equal(t, 5, inner.calls)
+}
+
+func TestLastKnownGoodWhenRefreshFailsAndCacheExpired(t *testing.T) {
+	provider, inner, now := buildCachingTarget(WithLastKnownGoodOnError())
+
+	provider.Retrieve(context.Background())
+	*now = now.Add(time.Hour)
+	inner.err = errors.New("throttled")
+	_, err := provider.Retrieve(context.Background())
+
+	assert.EqualError(t, err, "throttled")
+}
+
+func TestRefreshFailureWithoutLastKnownGood(t *testing.T) {
+	provider, inner, now := buildCachingTarget()
+
+	provider.Retrieve(context.Background())
+	*now = now.Add(59 * time.Minute)
+	inner.err = errors.New("throttled")
+	_, err := provider.Retrieve(context.Background())
+
+	assert.EqualError(t, err, "throttled")
+}
+
+func TestCacheTTL(t *testing.T) {
+	provider, inner, now := buildCachingTarget(WithCacheTTL(10 * time.Minute))
+
+	provider.Retrieve(context.Background())
+	*now = now.Add(9 * time.Minute)
+	provider.Retrieve(context.Background())
+	assert.Equal(t, 1, inner.calls)
+
+	*now = now.Add(time.Minute)
+	provider.Retrieve(context.Background())
+	assert.Equal(t, 2, inner.calls)
+}
+
+func TestCacheTTLLongerThanExpiration(t *testing.T) {
+	provider, inner, now := buildCachingTarget(WithCacheTTL(2 * time.Hour))
+
+	provider.Retrieve(context.Background())
+	*now = now.Add(55 * time.Minute)
+	provider.Retrieve(context.Background())
+
+	assert.Equal(t, 2, inner.calls)
+}
+
+func TestCachingCredentialsCallback(t *testing.T) {
+	var mu sync.Mutex
+	calls := 0
+	inner := func() (SigV4Credentials, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		return SigV4Credentials{AccessKeyId: "UserID-1", SecretAccessKey: "UserSecretKey-1"}, nil
+	}
+	callback := NewCachingCredentialsCallback(inner, time.Hour)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			credentials, err := callback()
+			assert.NoError(t, err)
+			assert.Equal(t, "UserID-1", credentials.AccessKeyId)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 1, calls)
+}
+
+func TestCachingCredentialsCallbackRefreshJitter(t *testing.T) {
+	now, _ := time.Parse(time.RFC3339, "2020-06-09T22:41:51Z")
+	calls := 0
+	inner := func() (SigV4Credentials, error) {
+		calls++
+		return SigV4Credentials{AccessKeyId: "UserID-1", SecretAccessKey: "UserSecretKey-1"}, nil
+	}
+	callback := NewCachingCredentialsCallback(inner, time.Hour, WithRefreshJitter(10*time.Minute),
+		func(provider *cachingProvider) {
+			provider.now = func() time.Time { return now }
+			provider.randInt63 = func(n int64) int64 { return int64(3 * time.Minute) }
+		})
+
+	callback()
+	now = now.Add(56*time.Minute + 59*time.Second)
+	callback()
+	assert.Equal(t, 1, calls)
+
+	now = now.Add(time.Second)
+	callback()
+	assert.Equal(t, 2, calls)
+}
+
+// blocks each retrieval until released or its ctx is done
+type blockingProvider struct {
+	release chan struct{}
+	calls   chan struct{}
+}
+
+func (p *blockingProvider) Retrieve(ctx context.Context) (SigV4Credentials, error) {
+	p.calls <- struct{}{}
+	select {
+	case <-p.release:
+		return SigV4Credentials{AccessKeyId: "UserID-1", SecretAccessKey: "UserSecretKey-1"}, nil
+	case <-ctx.Done():
+		return SigV4Credentials{}, ctx.Err()
+	}
+}
+
+func TestCachingProviderWaitersHonorTheirContext(t *testing.T) {
+	inner := &blockingProvider{release: make(chan struct{}), calls: make(chan struct{}, 2)}
+	provider := NewCachingCredentialProvider(inner)
+
+	refreshed := make(chan error, 1)
+	go func() {
+		_, err := provider.Retrieve(context.Background())
+		refreshed <- err
+	}()
+	<-inner.calls
+
+	// a slow refresh in flight must not hold up a waiting challenge past its deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := provider.Retrieve(ctx)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	close(inner.release)
+	assert.NoError(t, <-refreshed)
+	assert.Len(t, inner.calls, 0)
+}
+
+func TestCachingProviderWaiterRetriesWhenRefreshTimesOut(t *testing.T) {
+	inner := &blockingProvider{release: make(chan struct{}), calls: make(chan struct{}, 2)}
+	provider := NewCachingCredentialProvider(inner)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	refreshed := make(chan error, 1)
+	go func() {
+		_, err := provider.Retrieve(ctx)
+		refreshed <- err
+	}()
+	<-inner.calls
+
+	waited := make(chan error, 1)
+	go func() {
+		_, err := provider.Retrieve(context.Background())
+		waited <- err
+	}()
+	cancel()
+	assert.True(t, errors.Is(<-refreshed, context.Canceled))
+
+	// the waiter starts its own refresh instead of failing with the other challenge's error
+	<-inner.calls
+	close(inner.release)
+	assert.NoError(t, <-waited)
+}
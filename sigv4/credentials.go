@@ -0,0 +1,123 @@
+/*
+ *  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package sigv4
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Source of SigV4 credentials consulted by the authenticator on every challenge.
+// implementations should populate Expiration when it is known and must be safe for
+// concurrent use, as gocql authenticates connections from multiple goroutines.
+type CredentialProvider interface {
+	Retrieve(ctx context.Context) (SigV4Credentials, error)
+}
+
+// allows a plain callback to be used wherever a CredentialProvider is expected.
+// the context is not available to the callback.
+func (callback SigV4CredentialsCallback) Retrieve(ctx context.Context) (SigV4Credentials, error) {
+	return callback()
+}
+
+// Callback used to retrieve V4 credentials with a context, which is cancelled once the
+// authenticator's CredentialsTimeout elapses so a hung credential source can't block the handshake
+type SigV4CredentialsCallbackCtx func(ctx context.Context) (SigV4Credentials, error)
+
+func (callback SigV4CredentialsCallbackCtx) Retrieve(ctx context.Context) (SigV4Credentials, error) {
+	return callback(ctx)
+}
+
+// the fixed credentials of an AwsAuthenticator's credential fields, as a CredentialProvider
+type staticCredentialProvider SigV4Credentials
+
+func (p staticCredentialProvider) Retrieve(ctx context.Context) (SigV4Credentials, error) {
+	return SigV4Credentials(p), nil
+}
+
+// credentials replaced at runtime by UpdateCredentials, shared by every copy of the authenticator
+type updatableCredentialProvider struct {
+	mu          sync.RWMutex
+	credentials SigV4Credentials
+}
+
+func (p *updatableCredentialProvider) Retrieve(ctx context.Context) (SigV4Credentials, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.credentials, nil
+}
+
+// initializes authenticator with static credentials that UpdateCredentials can replace while
+// the cluster is running, e.g. after rotating an IAM user's access keys. copies of the
+// authenticator, including the one held by gocql, share the credentials, so reconnects pick up
+// the update without recreating the cluster.
+func NewAwsAuthenticatorWithUpdatableCredentials(region string, credentials SigV4Credentials, opts ...Option) AwsAuthenticator {
+	return NewAwsAuthenticatorWithCredentialProvider(region, &updatableCredentialProvider{credentials: credentials}, opts...)
+}
+
+// replaces the credentials of an authenticator created by NewAwsAuthenticatorWithUpdatableCredentials.
+// safe to call while connections are being established, challenges started afterwards sign
+// with the new credentials. credentials that could not be signed with are rejected and the
+// previous ones kept.
+func (p AwsAuthenticator) UpdateCredentials(credentials SigV4Credentials) error {
+	provider, ok := p.CredentialProvider.(*updatableCredentialProvider)
+	if !ok {
+		return errors.New("credentials can only be updated on authenticators created by NewAwsAuthenticatorWithUpdatableCredentials")
+	}
+	if err := validateCredentials(credentials, time.Now()); err != nil {
+		return err
+	}
+
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+	provider.credentials = credentials
+	return nil
+}
+
+// consults primary and falls back to static credentials when it fails
+type fallbackCredentialProvider struct {
+	primary  CredentialProvider
+	fallback SigV4Credentials
+	logger   Logger
+}
+
+func (p *fallbackCredentialProvider) Retrieve(ctx context.Context) (SigV4Credentials, error) {
+	credentials, err := p.primary.Retrieve(ctx)
+	if err != nil {
+		if p.logger != nil {
+			p.logger.Debugf("sigv4: primary credentials failed: %v, falling back to access key %s", err, p.fallback.AccessKeyId)
+		}
+		return p.fallback, nil
+	}
+	return credentials, nil
+}
+
+// initializes authenticator retrieving credentials from primary, e.g. a short-lived token source,
+// and signing with the static fallback instead of failing the connection when primary returns
+// an error. each fallback is logged to the Logger set by the options. a nil primary signs with
+// the fallback on every challenge.
+func NewAwsAuthenticatorWithFallback(region string, primary SigV4CredentialsCallback, fallback SigV4Credentials, opts ...Option) AwsAuthenticator {
+	if primary == nil {
+		return NewAwsAuthenticatorWithCredentialProvider(region, staticCredentialProvider(fallback), opts...)
+	}
+	provider := &fallbackCredentialProvider{primary: primary, fallback: fallback}
+	auth := NewAwsAuthenticatorWithCredentialProvider(region, provider, opts...)
+	provider.logger = auth.Logger
+	return auth
+}
@@ -0,0 +1,276 @@
+/*
+ *  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package sigv4
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/stretchr/testify/assert"
+)
+
+type fixedProvider struct {
+	credentials SigV4Credentials
+}
+
+func (p fixedProvider) Retrieve(ctx context.Context) (SigV4Credentials, error) {
+	return p.credentials, nil
+}
+
+func TestCallbackIsCredentialProvider(t *testing.T) {
+	var provider CredentialProvider = SigV4CredentialsCallback(func() (SigV4Credentials, error) {
+		return SigV4Credentials{AccessKeyId: "UserID-1"}, nil
+	})
+
+	credentials, err := provider.Retrieve(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "UserID-1", credentials.AccessKeyId)
+}
+
+func TestCredentialProvider(t *testing.T) {
+	provider := fixedProvider{SigV4Credentials{
+		AccessKeyId:     "UserID-1",
+		SecretAccessKey: "UserSecretKey-1",
+	}}
+	target := NewAwsAuthenticatorWithCredentialProvider("us-west-2", provider)
+	target.Clock = stdClock
+
+	_, challenger, _ := target.Challenge(nil)
+
+	resp, _, _ := challenger.Challenge(stdNonce)
+	expected := "signature=7f3691c18a81b8ce7457699effbfae5b09b4e0714ab38c1292dbdf082c9ddd87,access_key=UserID-1,amzdate=2020-06-09T22:41:51.000Z"
+	assert.Equal(t, expected, string(resp))
+}
+
+func TestCredentialProviderTakesPrecedenceOverCallback(t *testing.T) {
+	callback := func() (SigV4Credentials, error) {
+		return SigV4Credentials{AccessKeyId: "callback"}, nil
+	}
+	target := NewAwsAuthenticatorWithCredentialCallback("us-west-2", callback)
+	target.CredentialProvider = fixedProvider{SigV4Credentials{AccessKeyId: "provider"}}
+
+	credentials, _ := target.credentialProvider().Retrieve(context.Background())
+	assert.Equal(t, "provider", credentials.AccessKeyId)
+}
+
+func TestStaticCredentialsAreAdaptedToProvider(t *testing.T) {
+	target := buildStdTarget()
+	target.SessionToken = "sess-token-1"
+
+	credentials, err := target.signingCredentialProvider().Retrieve(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, SigV4Credentials{
+		AccessKeyId:     "UserID-1",
+		SecretAccessKey: "UserSecretKey-1",
+		SessionToken:    "sess-token-1"}, credentials)
+
+	target.CredentialProvider = fixedProvider{SigV4Credentials{AccessKeyId: "provider"}}
+	credentials, _ = target.signingCredentialProvider().Retrieve(context.Background())
+	assert.Equal(t, "provider", credentials.AccessKeyId)
+}
+
+func TestExpiredCredentials(t *testing.T) {
+	now, _ := time.Parse(time.RFC3339, "2020-06-09T22:41:51Z")
+	provider := fixedProvider{SigV4Credentials{
+		AccessKeyId:     "UserID-1",
+		SecretAccessKey: "UserSecretKey-1",
+		SessionToken:    "sess-token-1",
+		Expiration:      now.Add(-time.Second),
+	}}
+	target := NewAwsAuthenticatorWithCredentialProvider("us-west-2", provider)
+	target.Clock = func() time.Time { return now }
+
+	_, challenger, _ := target.Challenge(nil)
+	resp, _, err := challenger.Challenge(stdNonce)
+
+	assert.Nil(t, resp)
+	assert.True(t, errors.Is(err, ErrCredentialsExpired))
+	assert.EqualError(t, err, "AWS credentials have expired, session token expired at 2020-06-09T22:41:50Z")
+
+	provider.credentials.SessionToken = ""
+	target.CredentialProvider = provider
+	_, challenger, _ = target.Challenge(nil)
+	_, _, err = challenger.Challenge(stdNonce)
+	assert.EqualError(t, err, "AWS credentials have expired at 2020-06-09T22:41:50Z")
+}
+
+func TestExpiredCredentialsAreRetrievedAgain(t *testing.T) {
+	now, _ := time.Parse(time.RFC3339, "2020-06-09T22:41:51Z")
+	calls := 0
+	target := NewAwsAuthenticatorWithCredentialCallback("us-west-2", func() (SigV4Credentials, error) {
+		calls++
+		credentials := SigV4Credentials{
+			AccessKeyId:     "UserID-1",
+			SecretAccessKey: "UserSecretKey-1",
+			Expiration:      now.Add(time.Hour)}
+		if calls == 1 {
+			credentials.Expiration = now.Add(-time.Second)
+		}
+		return credentials, nil
+	})
+	target.Clock = func() time.Time { return now }
+
+	_, challenger, _ := target.Challenge(nil)
+	_, _, err := challenger.Challenge(stdNonce)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, calls)
+}
+
+func TestUnexpiredCredentials(t *testing.T) {
+	now, _ := time.Parse(time.RFC3339, "2020-06-09T22:41:51Z")
+	provider := fixedProvider{SigV4Credentials{
+		AccessKeyId:     "UserID-1",
+		SecretAccessKey: "UserSecretKey-1",
+		Expiration:      now.Add(time.Second),
+	}}
+	target := NewAwsAuthenticatorWithCredentialProvider("us-west-2", provider)
+	target.Clock = func() time.Time { return now }
+
+	_, challenger, _ := target.Challenge(nil)
+	_, _, err := challenger.Challenge(stdNonce)
+
+	assert.NoError(t, err)
+}
+
+func TestCallbackCtx(t *testing.T) {
+	callback := func(ctx context.Context) (SigV4Credentials, error) {
+		_, hasDeadline := ctx.Deadline()
+		assert.True(t, hasDeadline)
+		return SigV4Credentials{
+			AccessKeyId:     "UserID-1",
+			SecretAccessKey: "UserSecretKey-1",
+		}, nil
+	}
+	target := NewAwsAuthenticatorWithCredentialCallbackCtx("us-west-2", callback)
+	target.Clock = stdClock
+
+	_, challenger, _ := target.Challenge(nil)
+
+	resp, _, _ := challenger.Challenge(stdNonce)
+	expected := "signature=7f3691c18a81b8ce7457699effbfae5b09b4e0714ab38c1292dbdf082c9ddd87,access_key=UserID-1,amzdate=2020-06-09T22:41:51.000Z"
+	assert.Equal(t, expected, string(resp))
+}
+
+func TestCallbackCtxTimeout(t *testing.T) {
+	callback := func(ctx context.Context) (SigV4Credentials, error) {
+		<-ctx.Done()
+		return SigV4Credentials{}, ctx.Err()
+	}
+	target := NewAwsAuthenticatorWithCredentialCallbackCtx("us-west-2", callback)
+	target.CredentialsTimeout = 10 * time.Millisecond
+
+	_, challenger, _ := target.Challenge(nil)
+	_, _, err := challenger.Challenge(stdNonce)
+
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+}
+
+func TestUpdateCredentials(t *testing.T) {
+	target := NewAwsAuthenticatorWithUpdatableCredentials("us-west-2", SigV4Credentials{
+		AccessKeyId:     "UserID-1",
+		SecretAccessKey: "UserSecretKey-1"}, WithClock(stdClock))
+	// the cluster holds its own copy
+	var cluster gocql.Authenticator = target
+
+	assert.NoError(t, target.UpdateCredentials(SigV4Credentials{
+		AccessKeyId:     "UserID-2",
+		SecretAccessKey: "UserSecretKey-2"}))
+	_, challenger, _ := cluster.Challenge(nil)
+	resp, _, err := challenger.Challenge(stdNonce)
+	assert.NoError(t, err)
+	ok, _ := VerifySignedResponse("us-west-2", "91703fdc2ef562e19fbdab0f58e42fe5", "UserSecretKey-2", string(resp), stdClock())
+	assert.True(t, ok)
+
+	// invalid credentials are rejected and the current ones kept
+	assert.EqualError(t, target.UpdateCredentials(SigV4Credentials{AccessKeyId: "UserID-3"}), "AWS secret access key is empty")
+	credentials, _ := target.credentialProvider().Retrieve(context.Background())
+	assert.Equal(t, "UserID-2", credentials.AccessKeyId)
+}
+
+func TestUpdateCredentialsConcurrently(t *testing.T) {
+	target := NewAwsAuthenticatorWithUpdatableCredentials("us-west-2", SigV4Credentials{
+		AccessKeyId:     "UserID-1",
+		SecretAccessKey: "UserSecretKey-1"})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			assert.NoError(t, target.UpdateCredentials(SigV4Credentials{
+				AccessKeyId:     fmt.Sprintf("UserID-%d", i),
+				SecretAccessKey: fmt.Sprintf("UserSecretKey-%d", i)}))
+		}(i)
+		go func() {
+			defer wg.Done()
+			_, challenger, _ := target.Challenge(nil)
+			_, _, err := challenger.Challenge(stdNonce)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+}
+
+func TestUpdateCredentialsRequiresUpdatableAuthenticator(t *testing.T) {
+	err := buildStdTarget().UpdateCredentials(SigV4Credentials{AccessKeyId: "UserID-2", SecretAccessKey: "UserSecretKey-2"})
+	assert.EqualError(t, err, "credentials can only be updated on authenticators created by NewAwsAuthenticatorWithUpdatableCredentials")
+}
+
+func TestFallbackCredentials(t *testing.T) {
+	var primaryErr error
+	primary := func() (SigV4Credentials, error) {
+		if primaryErr != nil {
+			return SigV4Credentials{}, primaryErr
+		}
+		return SigV4Credentials{AccessKeyId: "UserID-2", SecretAccessKey: "UserSecretKey-2"}, nil
+	}
+	logger := &recordingLogger{}
+	target := NewAwsAuthenticatorWithFallback("us-west-2", primary,
+		SigV4Credentials{AccessKeyId: "UserID-1", SecretAccessKey: "UserSecretKey-1"},
+		WithClock(stdClock), WithLogger(logger))
+
+	credentials, err := target.credentialProvider().Retrieve(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "UserID-2", credentials.AccessKeyId)
+
+	primaryErr = errors.New("token source unavailable")
+	_, challenger, _ := target.Challenge(nil)
+	resp, _, err := challenger.Challenge(stdNonce)
+	assert.NoError(t, err)
+	expected := "signature=7f3691c18a81b8ce7457699effbfae5b09b4e0714ab38c1292dbdf082c9ddd87,access_key=UserID-1,amzdate=2020-06-09T22:41:51.000Z"
+	assert.Equal(t, expected, string(resp))
+	assert.Contains(t, logger.lines, "sigv4: primary credentials failed: token source unavailable, falling back to access key UserID-1")
+}
+
+func TestFallbackCredentialsNilPrimary(t *testing.T) {
+	target := NewAwsAuthenticatorWithFallback("us-west-2", nil,
+		SigV4Credentials{AccessKeyId: "UserID-1", SecretAccessKey: "UserSecretKey-1"}, WithClock(stdClock))
+
+	_, challenger, _ := target.Challenge(nil)
+	var resp []byte
+	var err error
+	assert.NotPanics(t, func() { resp, _, err = challenger.Challenge(stdNonce) })
+	assert.NoError(t, err)
+	expected := "signature=7f3691c18a81b8ce7457699effbfae5b09b4e0714ab38c1292dbdf082c9ddd87,access_key=UserID-1,amzdate=2020-06-09T22:41:51.000Z"
+	assert.Equal(t, expected, string(resp))
+}
@@ -0,0 +1,62 @@
+/*
+ *  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package sigv4
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// mints authenticators for several regions or keyspaces from a single AWS SDK session, so the
+// credential chain is walked and IMDS probed once rather than per authenticator. all of them
+// share the session's credentials, which the SDK refreshes, and its HTTP client.
+// safe for concurrent use.
+type AuthenticatorFactory struct {
+	sess *session.Session
+	opts []Option
+}
+
+// creates the shared session from cfg, e.g. with a custom HTTP client, and retrieves its
+// credentials once so misconfiguration is reported here. opts are applied to every
+// authenticator the factory creates.
+func NewAuthenticatorFactory(cfg *aws.Config, opts ...Option) (*AuthenticatorFactory, error) {
+	sess, err := session.NewSession(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AWS session: %w", err)
+	}
+	return NewAuthenticatorFactoryFromSession(sess, opts...)
+}
+
+// same as NewAuthenticatorFactory, sharing an existing session
+func NewAuthenticatorFactoryFromSession(sess *session.Session, opts ...Option) (*AuthenticatorFactory, error) {
+	if _, err := sess.Config.Credentials.Get(); err != nil {
+		return nil, fmt.Errorf("failed to retrieve AWS credentials: %w", err)
+	}
+	return &AuthenticatorFactory{sess: sess, opts: opts}, nil
+}
+
+// initializes authenticator signing for region with the shared credentials. opts are applied
+// after those of the factory. an empty region uses the region of the session.
+func (f *AuthenticatorFactory) NewAuthenticator(region string, opts ...Option) (AwsAuthenticator, error) {
+	if region == "" {
+		region = aws.StringValue(f.sess.Config.Region)
+	}
+	all := append(append([]Option{}, f.opts...), opts...)
+	return withValidRegion(newAwsAuthenticatorFromSession(f.sess, region, all))
+}
@@ -0,0 +1,103 @@
+/*
+ *  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package sigv4
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/stretchr/testify/assert"
+)
+
+// an SDK provider counting retrievals, whose credentials never expire once retrieved
+type countingSDKProvider struct {
+	mu        sync.Mutex
+	calls     int
+	retrieved bool
+	err       error
+}
+
+func (p *countingSDKProvider) Retrieve() (credentials.Value, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls++
+	if p.err != nil {
+		return credentials.Value{}, p.err
+	}
+	p.retrieved = true
+	return credentials.Value{AccessKeyID: "UserID-1", SecretAccessKey: "UserSecretKey-1"}, nil
+}
+
+func (p *countingSDKProvider) IsExpired() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return !p.retrieved
+}
+
+func TestAuthenticatorFactorySharesCredentials(t *testing.T) {
+	provider := &countingSDKProvider{}
+	factory, err := NewAuthenticatorFactory(aws.NewConfig().
+		WithRegion("us-west-2").
+		WithCredentials(credentials.NewCredentials(provider)), WithClock(stdClock))
+	assert.NoError(t, err)
+
+	for _, region := range []string{"", "us-east-1", "eu-west-1"} {
+		target, err := factory.NewAuthenticator(region)
+		assert.NoError(t, err)
+		assert.NoError(t, target.Validate())
+	}
+	assert.Equal(t, 1, provider.calls)
+
+	target, _ := factory.NewAuthenticator("")
+	assert.Equal(t, "us-west-2", target.Region)
+	_, challenger, _ := target.Challenge(nil)
+	resp, _, err := challenger.Challenge(stdNonce)
+	assert.NoError(t, err)
+	expected := "signature=7f3691c18a81b8ce7457699effbfae5b09b4e0714ab38c1292dbdf082c9ddd87,access_key=UserID-1,amzdate=2020-06-09T22:41:51.000Z"
+	assert.Equal(t, expected, string(resp))
+}
+
+func TestAuthenticatorFactoryAppliesOptions(t *testing.T) {
+	sess := session.Must(session.NewSession(aws.NewConfig().
+		WithCredentials(credentials.NewStaticCredentials("UserID-1", "UserSecretKey-1", ""))))
+	factory, err := NewAuthenticatorFactoryFromSession(sess, WithService("mock"), WithHost("localhost"))
+	assert.NoError(t, err)
+
+	target, err := factory.NewAuthenticator("us-west-2", WithService("cassandra"))
+	assert.NoError(t, err)
+	assert.Equal(t, "cassandra", target.Service)
+	assert.Equal(t, "localhost", target.Host)
+}
+
+func TestAuthenticatorFactoryErrors(t *testing.T) {
+	provider := &countingSDKProvider{err: errors.New("no credentials")}
+	_, err := NewAuthenticatorFactory(aws.NewConfig().WithCredentials(credentials.NewCredentials(provider)))
+	assert.EqualError(t, err, "failed to retrieve AWS credentials: no credentials")
+
+	os.Unsetenv("AWS_REGION")
+	os.Unsetenv("AWS_DEFAULT_REGION")
+	sess := session.Must(session.NewSession(aws.NewConfig().
+		WithCredentials(credentials.NewStaticCredentials("UserID-1", "UserSecretKey-1", ""))))
+	factory, _ := NewAuthenticatorFactoryFromSession(sess)
+	_, err = factory.NewAuthenticator("")
+	assert.True(t, errors.Is(err, ErrRegionNotConfigured))
+}
@@ -0,0 +1,349 @@
+/*
+ *  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package sigv4
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/stretchr/testify/assert"
+)
+
+// opcodes of the CQL native protocol v4 frames exchanged by the mock server
+const (
+	opError         byte = 0x00
+	opStartup       byte = 0x01
+	opReady         byte = 0x02
+	opAuthenticate  byte = 0x03
+	opOptions       byte = 0x05
+	opSupported     byte = 0x06
+	opRegister      byte = 0x0B
+	opAuthChallenge byte = 0x0E
+	opAuthResponse  byte = 0x0F
+	opAuthSuccess   byte = 0x10
+)
+
+const (
+	mockProtoVersion      = 4
+	mockServerErrorCode   = 0x0000
+	mockAuthErrorCode     = 0x0100
+	mockProtocolErrorCode = 0x000A
+)
+
+// a frame header is the version, with the high bit set on responses, flags, the stream id,
+// the opcode and the big endian length of the body
+func writeFrame(w io.Writer, stream uint16, op byte, body []byte) error {
+	frame := make([]byte, 9, 9+len(body))
+	frame[0] = 0x80 | mockProtoVersion
+	binary.BigEndian.PutUint16(frame[2:], stream)
+	frame[4] = op
+	binary.BigEndian.PutUint32(frame[5:], uint32(len(body)))
+	_, err := w.Write(append(frame, body...))
+	return err
+}
+
+func readFrame(r io.Reader) (uint16, byte, []byte, error) {
+	header := make([]byte, 9)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, 0, nil, err
+	}
+	if header[0] != mockProtoVersion {
+		return 0, 0, nil, fmt.Errorf("unexpected request version %#x", header[0])
+	}
+	body := make([]byte, binary.BigEndian.Uint32(header[5:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, 0, nil, err
+	}
+	return binary.BigEndian.Uint16(header[2:]), header[4], body, nil
+}
+
+// [string]: a big endian short length followed by the bytes
+func cqlString(s string) []byte {
+	b := make([]byte, 2, 2+len(s))
+	binary.BigEndian.PutUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+// [bytes]: a big endian int length followed by the bytes, -1 for null
+func cqlBytes(data []byte) []byte {
+	b := make([]byte, 4, 4+len(data))
+	if data == nil {
+		binary.BigEndian.PutUint32(b, 0xFFFFFFFF)
+		return b
+	}
+	binary.BigEndian.PutUint32(b, uint32(len(data)))
+	return append(b, data...)
+}
+
+func readCQLBytes(body []byte) ([]byte, error) {
+	if len(body) < 4 || int(binary.BigEndian.Uint32(body)) != len(body)-4 {
+		return nil, fmt.Errorf("malformed [bytes] %q", body)
+	}
+	return body[4:], nil
+}
+
+func errorBody(code uint32, message string) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, code)
+	return append(b, cqlString(message)...)
+}
+
+// computes the signature of a challenge response following the SigV4 specification, without
+// any of the plugin's signing code, so a regression there can't also hide in the verification
+func referenceSignature(region string, secret string, accessKeyId string, nonce string, t time.Time) string {
+	sum := func(data string) string {
+		h := sha256.Sum256([]byte(data))
+		return hex.EncodeToString(h[:])
+	}
+	mac := func(key []byte, data string) []byte {
+		h := hmac.New(sha256.New, key)
+		h.Write([]byte(data))
+		return h.Sum(nil)
+	}
+
+	dateStamp := t.Format("20060102")
+	amzDate := t.Format("2006-01-02T15:04:05.000Z")
+	scope := dateStamp + "/" + region + "/cassandra/aws4_request"
+	canonicalRequest := "PUT\n/authenticate\n" +
+		"X-Amz-Algorithm=AWS4-HMAC-SHA256" +
+		"&X-Amz-Credential=" + accessKeyId + "%2F" + strings.Replace(scope, "/", "%2F", -1) +
+		"&X-Amz-Date=" + strings.Replace(amzDate, ":", "%3A", -1) +
+		"&X-Amz-Expires=900\n" +
+		"host:cassandra\n\n" +
+		"host\n" +
+		sum(nonce)
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sum(canonicalRequest)
+
+	key := mac([]byte("AWS4"+secret), dateStamp)
+	key = mac(key, region)
+	key = mac(key, "cassandra")
+	key = mac(key, "aws4_request")
+	return hex.EncodeToString(mac(key, stringToSign))
+}
+
+// speaks the Amazon Keyspaces side of the CQL startup: it answers OPTIONS, requests SigV4
+// authentication in response to STARTUP, expects the initial SigV4 response, challenges with a
+// random nonce and verifies the signed response against secrets, keyed by access key id, at the
+// fixed server time of stdClock. after the handshake every query fails, so gocql closes the
+// connection and the session it sets up fails, only the handshake outcome is of interest.
+type mockKeyspacesServer struct {
+	listener net.Listener
+	region   string
+	secrets  map[string]string
+
+	mu            sync.Mutex
+	sessionTokens []string // session tokens of the accepted responses
+}
+
+func startMockKeyspacesServer(t *testing.T, secrets map[string]string) *mockKeyspacesServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &mockKeyspacesServer{listener: listener, region: "us-west-2", secrets: secrets}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return server
+}
+
+func (s *mockKeyspacesServer) serve(conn net.Conn) {
+	defer conn.Close()
+	var nonce string
+	for {
+		stream, op, body, err := readFrame(conn)
+		if err != nil {
+			return
+		}
+		switch {
+		case op == opOptions:
+			// an empty [string multimap], no compression or other options supported
+			err = writeFrame(conn, stream, opSupported, []byte{0, 0})
+		case op == opStartup:
+			err = writeFrame(conn, stream, opAuthenticate, cqlString("com.amazonaws.cassandra.auth.AwsSigV4Authenticator"))
+		case op == opAuthResponse && nonce == "":
+			nonce, err = s.challenge(conn, stream, body)
+		case op == opAuthResponse:
+			err = s.verify(conn, stream, nonce, body)
+		case op == opRegister:
+			err = writeFrame(conn, stream, opReady, nil)
+		default:
+			err = writeFrame(conn, stream, opError, errorBody(mockServerErrorCode, "the mock server only authenticates"))
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (s *mockKeyspacesServer) challenge(conn net.Conn, stream uint16, body []byte) (string, error) {
+	resp, err := readCQLBytes(body)
+	if err != nil || string(resp) != "SigV4\000\000" {
+		return "", writeFrame(conn, stream, opError, errorBody(mockProtocolErrorCode, fmt.Sprintf("unexpected initial response %q", body)))
+	}
+
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	nonce := hex.EncodeToString(raw)
+	return nonce, writeFrame(conn, stream, opAuthChallenge, cqlBytes([]byte("nonce="+nonce)))
+}
+
+func (s *mockKeyspacesServer) verify(conn net.Conn, stream uint16, nonce string, body []byte) error {
+	resp, err := readCQLBytes(body)
+	if err != nil {
+		return writeFrame(conn, stream, opError, errorBody(mockProtocolErrorCode, err.Error()))
+	}
+	params := map[string]string{}
+	for _, param := range strings.Split(string(resp), ",") {
+		if kv := strings.SplitN(param, "=", 2); len(kv) == 2 {
+			params[kv[0]] = kv[1]
+		}
+	}
+
+	accessKeyId := params["access_key"]
+	secret, known := s.secrets[accessKeyId]
+	expected := referenceSignature(s.region, secret, accessKeyId, nonce, stdClock())
+	if !known || params["amzdate"] != "2020-06-09T22:41:51.000Z" || params["signature"] != expected {
+		return writeFrame(conn, stream, opError,
+			errorBody(mockAuthErrorCode, "Provided username "+accessKeyId+" and/or password are incorrect"))
+	}
+
+	s.mu.Lock()
+	s.sessionTokens = append(s.sessionTokens, params["session_token"])
+	s.mu.Unlock()
+	return writeFrame(conn, stream, opAuthSuccess, cqlBytes(nil))
+}
+
+func (s *mockKeyspacesServer) cluster(auth gocql.Authenticator) *gocql.ClusterConfig {
+	addr := s.listener.Addr().(*net.TCPAddr)
+	cluster := gocql.NewCluster(addr.IP.String())
+	cluster.Port = addr.Port
+	cluster.ProtoVersion = mockProtoVersion
+	cluster.DisableInitialHostLookup = true
+	cluster.ConnectTimeout = 5 * time.Second
+	cluster.Authenticator = auth
+	return cluster
+}
+
+// runs the gocql startup against the mock and returns the outcome of the handshake, as
+// observed by the cluster's ConnectObserver
+func connectToMock(t *testing.T, cluster *gocql.ClusterConfig) error {
+	observer := &recordingConnectObserver{}
+	cluster.ConnectObserver = observer
+
+	session, err := cluster.CreateSession()
+	if !assert.Error(t, err, "the mock fails every query after the handshake") {
+		session.Close()
+	}
+	if !assert.Len(t, observer.observed, 1) {
+		return errors.New("no connection observed")
+	}
+	return observer.observed[0].Err
+}
+
+func TestReferenceSignature(t *testing.T) {
+	// the response golden tests expect for the standard inputs
+	assert.Equal(t, "7f3691c18a81b8ce7457699effbfae5b09b4e0714ab38c1292dbdf082c9ddd87",
+		referenceSignature("us-west-2", "UserSecretKey-1", "UserID-1", "91703fdc2ef562e19fbdab0f58e42fe5", stdClock()))
+}
+
+func TestHandshakeAgainstMockServer(t *testing.T) {
+	server := startMockKeyspacesServer(t, map[string]string{"UserID-1": "UserSecretKey-1"})
+
+	var succeeded bool
+	target := NewAwsAuthenticatorStatic("us-west-2", "UserID-1", "UserSecretKey-1", "", WithClock(stdClock))
+	target.OnSuccess = func(data []byte) { succeeded = true }
+
+	assert.NoError(t, connectToMock(t, server.cluster(target)))
+	assert.True(t, succeeded)
+	assert.Equal(t, []string{""}, server.sessionTokens)
+}
+
+func TestHandshakeAgainstMockServerWithSessionToken(t *testing.T) {
+	server := startMockKeyspacesServer(t, map[string]string{"UserID-1": "UserSecretKey-1"})
+	target := NewAwsAuthenticatorStatic("us-west-2", "UserID-1", "UserSecretKey-1", "sess-token-1", WithClock(stdClock))
+
+	assert.NoError(t, connectToMock(t, server.cluster(target)))
+	assert.Equal(t, []string{"sess-token-1"}, server.sessionTokens)
+}
+
+func TestHandshakeAgainstMockServerRejectsWrongSecret(t *testing.T) {
+	server := startMockKeyspacesServer(t, map[string]string{"UserID-1": "UserSecretKey-1"})
+	target := NewAwsAuthenticatorStatic("us-west-2", "UserID-1", "OtherSecretKey", "", WithClock(stdClock))
+
+	err := connectToMock(t, server.cluster(target))
+
+	assert.True(t, errors.Is(AuthenticationFailure(err), ErrAccessDenied))
+	assert.EqualError(t, AuthenticationFailure(err), "access denied: Provided username UserID-1 and/or password are incorrect")
+}
+
+func TestHandshakeSharedAuthenticatorAcrossClusters(t *testing.T) {
+	server := startMockKeyspacesServer(t, map[string]string{"UserID-1": "UserSecretKey-1", "UserID-2": "UserSecretKey-2"})
+	metrics := &countingMetrics{}
+	target := NewAwsAuthenticatorWithUpdatableCredentials("us-west-2",
+		SigV4Credentials{AccessKeyId: "UserID-1", SecretAccessKey: "UserSecretKey-1"},
+		WithClock(stdClock), WithMetrics(metrics))
+
+	// each cluster holds its own copy of the authenticator
+	clusters := []*gocql.ClusterConfig{server.cluster(target), server.cluster(target)}
+	const connectionsPerCluster = 8
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(clusters)*connectionsPerCluster)
+	for _, cluster := range clusters {
+		for i := 0; i < connectionsPerCluster; i++ {
+			wg.Add(1)
+			go func(cluster gocql.ClusterConfig) {
+				defer wg.Done()
+				errs <- connectToMock(t, &cluster)
+			}(*cluster)
+		}
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		assert.NoError(t, target.UpdateCredentials(SigV4Credentials{AccessKeyId: "UserID-2", SecretAccessKey: "UserSecretKey-2"}))
+	}()
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, len(clusters)*connectionsPerCluster, metrics.counters[MetricChallengesSigned])
+}
@@ -22,24 +22,90 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
-	"net/url"
+	"hash"
 	"sort"
 	"strings"
 	"time"
+	"unicode"
 )
 
+// returned by ExtractNonce when the payload holds no nonce parameter
+var ErrMissingNonce = errors.New("request does not contain nonce property")
+
+// returned by ExtractNonce when the nonce parameter is empty or contains whitespace or control
+// characters, and by ExtractHexNonce when it is not hexadecimal
+var ErrMalformedNonce = errors.New("malformed nonce")
+
+// upper bound on the challenge frame ExtractNonce parses. the nonce is 32 hex characters,
+// so frames far beyond this are malformed or hostile and are rejected before being split.
+const maxChallengeLength = 1024
+
 // extract the nonce from a request payload
 // needed for calls from payload returned by Amazon Keyspaces.
+// the payload is parsed as comma or ampersand separated key=value pairs, so the nonce
+// may appear alongside other parameters. the key is matched case-insensitively, e.g. Nonce=.
 func ExtractNonce(req []byte) (string, error) {
-	text := string(req)
-	if !strings.HasPrefix(text, "nonce=") {
-		return "", errors.New("request does not contain nonce property")
+	if len(req) > maxChallengeLength {
+		return "", fmt.Errorf("%w: challenge of %d bytes exceeds the %d byte limit", ErrMalformedNonce, len(req), maxChallengeLength)
+	}
+	params := strings.FieldsFunc(string(req), func(r rune) bool {
+		return r == ',' || r == '&'
+	})
+	for _, param := range params {
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) == 2 && strings.EqualFold(strings.TrimSpace(kv[0]), "nonce") {
+			return validateNonce(kv[1])
+		}
+	}
+
+	return "", ErrMissingNonce
+}
+
+// same as ExtractNonce, but also requires the nonce to be hexadecimal, the format Amazon
+// Keyspaces sends today. a 0x prefix is allowed and kept, as the server signs its nonce verbatim.
+func ExtractHexNonce(req []byte) (string, error) {
+	nonce, err := ExtractNonce(req)
+	if err != nil {
+		return "", err
+	}
+	digits := nonce
+	if strings.HasPrefix(digits, "0x") || strings.HasPrefix(digits, "0X") {
+		digits = digits[2:]
+	}
+	if digits == "" {
+		return "", fmt.Errorf("%w: %q has no hexadecimal digits", ErrMalformedNonce, nonce)
 	}
-	nonce := strings.Split(text, "nonce=")[1]
+	for _, r := range digits {
+		if !('0' <= r && r <= '9' || 'a' <= r && r <= 'f' || 'A' <= r && r <= 'F') {
+			return "", fmt.Errorf("%w: %q is not hexadecimal", ErrMalformedNonce, nonce)
+		}
+	}
+	return nonce, nil
+}
 
+// strips the line endings, NUL padding and whitespace a server frame may carry around the
+// nonce, which would otherwise be hashed into the signature. the rest is kept verbatim and only
+// checked for characters no nonce format would contain, so a server changing the format does
+// not break every handshake.
+func validateNonce(nonce string) (string, error) {
+	nonce = strings.TrimFunc(nonce, func(r rune) bool {
+		return r == '\x00' || unicode.IsSpace(r)
+	})
+	if nonce == "" {
+		return "", fmt.Errorf("%w: nonce is empty", ErrMalformedNonce)
+	}
+	for _, r := range nonce {
+		if unicode.IsSpace(r) || unicode.IsControl(r) {
+			return "", fmt.Errorf("%w: %q contains whitespace or control characters", ErrMalformedNonce, nonce)
+		}
+	}
 	return nonce, nil
 }
 
+// timestamp format of X-Amz-Date in the canonical request, the string to sign and the amzdate
+// of the response. all three must agree for the server to accept the signature.
+const AmzDateFormat = "2006-01-02T15:04:05.000Z"
+
 // Convert time to an aws credential timestamp
 // such as 2020-06-09T22:41:51.000Z -> '20200609'
 func toCredDateStamp(t time.Time) string {
@@ -47,64 +113,278 @@ func toCredDateStamp(t time.Time) string {
 }
 
 // compute the scope to be used in the request
-func computeScope(t time.Time, region string) string {
+func computeScope(t time.Time, region string, service string) string {
 	a := []string{
 		toCredDateStamp(t),
 		region,
-		"cassandra",
+		service,
 		"aws4_request"}
 	return strings.Join(a, "/")
 }
 
-func formCanonicalRequest(accessKeyId string, scope string, t time.Time, nonce string) string {
-	nonceHash := sha256.Sum256([]byte(nonce))
+// X-Amz-Expires used when a Signer does not set one
+const DefaultExpiresSeconds = 900
+
+// service name signed when a Signer does not set one
+const DefaultService = "cassandra"
+
+// host header signed when a Signer does not set one. Amazon Keyspaces verifies against this
+// fixed value, whichever partition (aws, aws-cn, aws-us-gov, ...) or endpoint is connected to.
+const DefaultHost = "cassandra"
+
+// Signer holds the parameters of the signing protocol that can differ from the Amazon Keyspaces
+// defaults. the zero value signs exactly like BuildSignedResponse.
+type Signer struct {
+	ExpiresSeconds int    // X-Amz-Expires, DefaultExpiresSeconds when not positive
+	Service        string // service in the credential scope and signing key, DefaultService when empty
+	Host           string // value of the signed host header, DefaultHost when empty
+	ScopeOverride  string // credential scope signed verbatim instead of the computed one, when set
+
+	// headers signed in addition to host, by name. names are matched case-insensitively and
+	// a host entry is ignored, the host header always takes its value from Host.
+	SignedHeaders map[string]string
+}
+
+// the service name used for both the scope and the signing key, which must agree
+func (s Signer) ServiceName() string {
+	if s.Service == "" {
+		return DefaultService
+	}
+	return s.Service
+}
+
+// the value of the host header in the canonical request
+func (s Signer) HostName() string {
+	if s.Host == "" {
+		return DefaultHost
+	}
+	return s.Host
+}
+
+// the X-Amz-Expires value actually signed, the single source for anything validating it
+func (s Signer) ExpiresIn() int {
+	if s.ExpiresSeconds <= 0 {
+		return DefaultExpiresSeconds
+	}
+	return s.ExpiresSeconds
+}
+
+// the credential scope signed for region at time t, e.g. 20200609/us-west-2/cassandra/aws4_request,
+// or ScopeOverride when set
+func (s Signer) Scope(t time.Time, region string) string {
+	if s.ScopeOverride != "" {
+		return s.ScopeOverride
+	}
+	return computeScope(t.UTC(), region, s.ServiceName())
+}
+
+// checks that scope has the date/region/service/aws4_request form the signing key is derived from
+func ValidateScope(scope string) error {
+	parts := strings.Split(scope, "/")
+	if len(parts) != 4 || parts[3] != "aws4_request" {
+		return fmt.Errorf("credential scope %q is not of the form date/region/service/aws4_request", scope)
+	}
+	for _, part := range parts[:3] {
+		if part == "" {
+			return fmt.Errorf("credential scope %q has an empty part", scope)
+		}
+	}
+	return nil
+}
+
+// percent-encodes a query parameter value as SigV4 specifies: everything but the unreserved
+// characters A-Z, a-z, 0-9, '-', '.', '_' and '~' is encoded, with upper case hex digits.
+// unlike url.QueryEscape, a space becomes %20 rather than '+'.
+func uriEncode(value string) string {
+	var encoded strings.Builder
+	for _, b := range []byte(value) {
+		if 'A' <= b && b <= 'Z' || 'a' <= b && b <= 'z' || '0' <= b && b <= '9' ||
+			b == '-' || b == '.' || b == '_' || b == '~' {
+			encoded.WriteByte(b)
+		} else {
+			fmt.Fprintf(&encoded, "%%%02X", b)
+		}
+	}
+	return encoded.String()
+}
+
+// the signing algorithm: its identifier in the canonical request and string to sign, and the
+// hash used for the digests and HMACs. everything signed goes through algorithm, so supporting
+// another variant, e.g. a SHA-384 one, only means swapping this value.
+type signingAlgorithm struct {
+	name    string
+	newHash func() hash.Hash
+}
+
+var algorithm = signingAlgorithm{name: "AWS4-HMAC-SHA256", newHash: sha256.New}
+
+// the hex encoded digest of data
+func (a signingAlgorithm) hexDigest(data string) string {
+	h := a.newHash()
+	h.Write([]byte(data))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// host is always signed, followed by any SignedHeaders. without them the signed headers line
+// is "host" and only the header value follows the signer's HostName.
+func (s Signer) formCanonicalRequest(accessKeyId string, scope string, t time.Time, nonce string) string {
 	headers := []string{
-		"X-Amz-Algorithm=AWS4-HMAC-SHA256",
-		fmt.Sprintf("X-Amz-Credential=%s%%2F%s", accessKeyId, url.QueryEscape(scope)),
-		fmt.Sprintf("X-Amz-Date=%s", url.QueryEscape(t.Format("2006-01-02T15:04:05.000Z"))),
-		"X-Amz-Expires=900"}
+		fmt.Sprintf("X-Amz-Algorithm=%s", algorithm.name),
+		fmt.Sprintf("X-Amz-Credential=%s", uriEncode(accessKeyId+"/"+scope)),
+		fmt.Sprintf("X-Amz-Date=%s", uriEncode(t.Format(AmzDateFormat))),
+		fmt.Sprintf("X-Amz-Expires=%d", s.ExpiresIn())}
 	sort.Strings(headers)
 	queryString := strings.Join(headers, "&")
 
-	return fmt.Sprintf("PUT\n/authenticate\n%s\nhost:cassandra\n\nhost\n%s", queryString, hex.EncodeToString(nonceHash[:]))
+	headerBlock, signedHeaders := s.canonicalHeaders()
+	return fmt.Sprintf("PUT\n/authenticate\n%s\n%s\n%s\n%s", queryString, headerBlock, signedHeaders, algorithm.hexDigest(nonce))
+}
+
+// the canonical header block, one lowercase name:value line per header sorted by name, and the
+// signed headers line listing the same names. values are trimmed and runs of spaces collapsed.
+func (s Signer) canonicalHeaders() (string, string) {
+	values := map[string]string{"host": s.HostName()}
+	for name, value := range s.SignedHeaders {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "host" {
+			values[name] = strings.Join(strings.Fields(value), " ")
+		}
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var headerBlock strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&headerBlock, "%s:%s\n", name, values[name])
+	}
+	return headerBlock.String(), strings.Join(names, ";")
+}
+
+// the credential scope and canonical request signed for the given parameters, exactly as
+// Signature computes them. neither depends on the secret, so they can be logged safely.
+func (s Signer) DescribeSigning(region string, nonce string, accessKeyId string, t time.Time) (string, string) {
+	t = t.UTC()
+	scope := s.Scope(t, region)
+	return scope, s.formCanonicalRequest(accessKeyId, scope, t, nonce)
 }
 
 // applies hmac with given string
-// useful as our protocol requires lots of iterative hmacs
+// useful as our protocol requires lots of iterative hmacs.
+// like all hashing here it goes through the standard library, which FIPS builds route
+// through their validated module, see the FIPS 140 section of the README.
 func applyHmac(data string, hashSecret []byte) []byte {
-	h := hmac.New(sha256.New, hashSecret)
+	h := hmac.New(algorithm.newHash, hashSecret)
 	h.Write([]byte(data))
 	return h.Sum(nil)
 }
 
-func deriveSigningKey(secret string, t time.Time, region string) []byte {
+// zeroes b, so secret material does not linger in memory once it is no longer needed
+func Wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+func deriveSigningKey(secret string, t time.Time, region string, service string) []byte {
+	secretBytes := []byte(secret)
+	defer Wipe(secretBytes)
+	return deriveSigningKeyBytes(secretBytes, t, region, service)
+}
+
+// same as deriveSigningKey. only the returned key survives, the keyed secret and the
+// intermediate HMAC results are wiped. secret itself is left to the caller.
+func deriveSigningKeyBytes(secret []byte, t time.Time, region string, service string) []byte {
+	return deriveScopedSigningKey(secret, []string{toCredDateStamp(t), region, service, "aws4_request"})
+}
+
+// derives the signing key from the parts of a credential scope, date first
+func deriveScopedSigningKey(secret []byte, scope []string) []byte {
 	// we successively apply the hmac secret in multiple iterations rather then simply
 	// write it once (as per the Amazon Keyspaces protocol)
-	s := "AWS4" + secret
-	h := applyHmac(toCredDateStamp(t), []byte(s))
-	h = applyHmac(region, h)
-	h = applyHmac("cassandra", h)
-	h = applyHmac("aws4_request", h)
+	s := make([]byte, 0, len("AWS4")+len(secret))
+	s = append(append(s, "AWS4"...), secret...)
+	h := applyHmac(scope[0], s)
+	Wipe(s)
+	for _, data := range scope[1:] {
+		next := applyHmac(data, h)
+		Wipe(h)
+		h = next
+	}
 	return h
 }
 
+// the signing key for region at time t, from the shared cache unless the scope is
+// overridden, in which case it is derived from the override's parts for this call only
+func (s Signer) signingKey(secret string, t time.Time, region string) []byte {
+	if s.ScopeOverride == "" {
+		return signingKeys.get(secret, t, region, s.ServiceName())
+	}
+	secretBytes := []byte(secret)
+	defer Wipe(secretBytes)
+	return deriveScopedSigningKey(secretBytes, strings.Split(s.ScopeOverride, "/"))
+}
+
 func createSignature(canonicalRequest string, t time.Time, signingScope string, signingKey []byte) []byte {
-	digest := sha256.Sum256([]byte(canonicalRequest))
-	s := fmt.Sprintf("AWS4-HMAC-SHA256\n%s\n%s\n%s", t.Format("2006-01-02T15:04:05.000Z"), signingScope, hex.EncodeToString(digest[:]))
+	return applyHmac(stringToSign(canonicalRequest, t, signingScope), []byte(signingKey))
+}
 
-	return applyHmac(s, []byte(signingKey))
+func stringToSign(canonicalRequest string, t time.Time, signingScope string) string {
+	return fmt.Sprintf("%s\n%s\n%s\n%s", algorithm.name, t.Format(AmzDateFormat), signingScope, algorithm.hexDigest(canonicalRequest))
 }
 
 // creates response that can be sent for a SigV4 challenge
 // this includes both the signature and the metadata supporting signature.
+// the credential scope date and X-Amz-Date are both derived from t, so they always
+// name the same UTC day, even when signing a second before midnight. t is converted to UTC
+// first, so a time in another zone signs exactly like the same instant in UTC.
+// the session token is appended verbatim: the response is comma separated rather than a query
+// string, and the server reads the token as is, so encoding its '+', '/' and '=' would break it.
 func BuildSignedResponse(region string, nonce string, accessKeyId string, secret string, sessionToken string, t time.Time) string {
-	scope := computeScope(t, region)
-	canonicalRequest := formCanonicalRequest(accessKeyId, scope, t, nonce)
-	signingKey := deriveSigningKey(secret, t, region)
+	return Signer{}.BuildSignedResponse(region, nonce, accessKeyId, secret, sessionToken, t)
+}
+
+// same as BuildSignedResponse, using the signer's protocol parameters
+func (s Signer) BuildSignedResponse(region string, nonce string, accessKeyId string, secret string, sessionToken string, t time.Time) string {
+	t = t.UTC()
+	signingKey := s.signingKey(secret, t, region)
+	return s.signWithKey(region, nonce, accessKeyId, sessionToken, t, signingKey)
+}
+
+// same as BuildSignedResponse, for callers minimizing the lifetime of secret material. the
+// signing key is derived for this call only, bypassing the shared key cache, and wiped
+// before returning. wiping secret once this returns is left to the caller.
+func (s Signer) BuildSignedResponseBytes(region string, nonce string, accessKeyId string, secret []byte, sessionToken string, t time.Time) string {
+	t = t.UTC()
+	var signingKey []byte
+	if s.ScopeOverride != "" {
+		signingKey = deriveScopedSigningKey(secret, strings.Split(s.ScopeOverride, "/"))
+	} else {
+		signingKey = deriveSigningKeyBytes(secret, t, region, s.ServiceName())
+	}
+	defer Wipe(signingKey)
+	return s.signWithKey(region, nonce, accessKeyId, sessionToken, t, signingKey)
+}
+
+// the hex encoded signature of a signed response, without the metadata supporting it
+func (s Signer) Signature(region string, nonce string, accessKeyId string, secret string, t time.Time) string {
+	t = t.UTC()
+	signingKey := s.signingKey(secret, t, region)
+	return s.signatureWithKey(region, nonce, accessKeyId, t, signingKey)
+}
+
+func (s Signer) signatureWithKey(region string, nonce string, accessKeyId string, t time.Time, signingKey []byte) string {
+	scope := s.Scope(t, region)
+	canonicalRequest := s.formCanonicalRequest(accessKeyId, scope, t, nonce)
+	return hex.EncodeToString(createSignature(canonicalRequest, t, scope, signingKey))
+}
 
-	signature := createSignature(canonicalRequest, t, scope, signingKey)
+func (s Signer) signWithKey(region string, nonce string, accessKeyId string, sessionToken string, t time.Time, signingKey []byte) string {
+	signature := s.signatureWithKey(region, nonce, accessKeyId, t, signingKey)
 
-	result := fmt.Sprintf("signature=%s,access_key=%s,amzdate=%s", hex.EncodeToString(signature), accessKeyId, t.Format("2006-01-02T15:04:05.000Z"))
+	result := fmt.Sprintf("signature=%s,access_key=%s,amzdate=%s", signature, accessKeyId, t.Format(AmzDateFormat))
 
 	if sessionToken != "" {
 		result += fmt.Sprintf(",session_token=%s", sessionToken)
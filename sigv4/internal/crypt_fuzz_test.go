@@ -0,0 +1,57 @@
+//go:build go1.18
+// +build go1.18
+
+/*
+ *  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package internal
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func FuzzExtractNonce(f *testing.F) {
+	for _, seed := range []string{
+		"nonce=91703fdc2ef562e19fbdab0f58e42fe5",
+		"realm=keyspaces,nonce=91703fdc2ef562e19fbdab0f58e42fe5,",
+		"nonce=91703fdc2ef562e19fbdab0f58e42fe5\r\n\x00",
+		"nonce=",
+		"nonce",
+		"=",
+		",,&&",
+		"",
+	} {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, req []byte) {
+		nonce, err := ExtractNonce(req)
+		if err != nil {
+			if !errors.Is(err, ErrMissingNonce) && !errors.Is(err, ErrMalformedNonce) {
+				t.Fatalf("unexpected error %v", err)
+			}
+			return
+		}
+		if nonce == "" || !strings.Contains(string(req), nonce) {
+			t.Fatalf("nonce %q not taken from %q", nonce, req)
+		}
+		if _, err := validateNonce(nonce); err != nil {
+			t.Fatalf("returned invalid nonce %q: %v", nonce, err)
+		}
+	})
+}
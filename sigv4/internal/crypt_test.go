@@ -17,8 +17,11 @@
 package internal
 
 import (
+	"crypto/sha512"
 	"encoding/hex"
+	"errors"
 	"github.com/stretchr/testify/assert"
+	"strings"
 	"testing"
 	"time"
 )
@@ -44,11 +47,108 @@ func TestExtractNonceSuccess(t *testing.T) {
 func TestExtractNonceMissing(t *testing.T) {
 	challenge := []byte("n1256")
 	_, err := ExtractNonce(challenge)
+	assert.Equal(t, ErrMissingNonce, err)
+}
+
+func TestExtractNonceInTheMiddle(t *testing.T) {
+	actualNonce, err := ExtractNonce([]byte("something=x,nonce=1256,other=y"))
+	assert.NoError(t, err)
+	assert.Equal(t, "1256", actualNonce)
+
+	actualNonce, err = ExtractNonce([]byte("something=x&nonce=1256&other=y"))
+	assert.NoError(t, err)
+	assert.Equal(t, "1256", actualNonce)
+}
+
+func TestExtractNonceWithTrailingComma(t *testing.T) {
+	actualNonce, err := ExtractNonce([]byte("nonce=1256,"))
+	assert.NoError(t, err)
+	assert.Equal(t, "1256", actualNonce)
+}
+
+func TestExtractNonceTrimsFraming(t *testing.T) {
+	for _, challenge := range []string{"nonce=1256\r\n", "nonce=1256\n", "nonce=1256\x00\x00", " nonce= 1256 ,other=y"} {
+		actualNonce, err := ExtractNonce([]byte(challenge))
+		assert.NoError(t, err, challenge)
+		assert.Equal(t, "1256", actualNonce, challenge)
+	}
+}
+
+func TestExtractNonceRejectsMalformedNonces(t *testing.T) {
+	for _, challenge := range []string{"nonce=12\x0056", "nonce=", "nonce=\r\n", "nonce=12 56"} {
+		_, err := ExtractNonce([]byte(challenge))
+		assert.True(t, errors.Is(err, ErrMalformedNonce), challenge)
+	}
+
+	_, err := ExtractNonce([]byte("nonce=12\x0056"))
+	assert.EqualError(t, err, `malformed nonce: "12\x0056" contains whitespace or control characters`)
+}
+
+func TestExtractNonceKeepsHexPrefix(t *testing.T) {
+	for _, challenge := range []string{"nonce=0x1256", "nonce=0x1256\r\n", "realm=keyspaces,nonce=0x1256"} {
+		actualNonce, err := ExtractNonce([]byte(challenge))
+		assert.NoError(t, err, challenge)
+		assert.Equal(t, "0x1256", actualNonce, challenge)
+
+		actualNonce, err = ExtractHexNonce([]byte(challenge))
+		assert.NoError(t, err, challenge)
+		assert.Equal(t, "0x1256", actualNonce, challenge)
+	}
+
+	actualNonce, err := ExtractHexNonce([]byte("nonce=0X1256"))
+	assert.NoError(t, err)
+	assert.Equal(t, "0X1256", actualNonce)
+
+	_, err = ExtractHexNonce([]byte("nonce=0x"))
+	assert.EqualError(t, err, `malformed nonce: "0x" has no hexadecimal digits`)
+}
+
+func TestExtractNonceAcceptsOtherFormats(t *testing.T) {
+	actualNonce, err := ExtractNonce([]byte("nonce=kR3m+9Qz/w=="))
+	assert.NoError(t, err)
+	assert.Equal(t, "kR3m+9Qz/w==", actualNonce)
+}
+
+func TestExtractHexNonce(t *testing.T) {
+	actualNonce, err := ExtractHexNonce([]byte("nonce=91703fdc2ef562e19fbdab0f58e42fe5"))
+	assert.NoError(t, err)
+	assert.Equal(t, "91703fdc2ef562e19fbdab0f58e42fe5", actualNonce)
+
+	_, err = ExtractHexNonce([]byte("nonce=xyz"))
+	assert.EqualError(t, err, `malformed nonce: "xyz" is not hexadecimal`)
+
+	_, err = ExtractHexNonce([]byte("other=1256"))
+	assert.Equal(t, ErrMissingNonce, err)
+}
+
+func TestExtractNonceRejectsOversizedChallenges(t *testing.T) {
+	challenge := []byte("nonce=1256," + strings.Repeat("a", maxChallengeLength))
+	_, err := ExtractNonce(challenge)
+	assert.True(t, errors.Is(err, ErrMalformedNonce))
+	assert.EqualError(t, err, "malformed nonce: challenge of 1035 bytes exceeds the 1024 byte limit")
+
+	actualNonce, err := ExtractNonce(challenge[:maxChallengeLength])
+	assert.NoError(t, err)
+	assert.Equal(t, "1256", actualNonce)
+}
+
+func TestExtractNonceIgnoresSimilarKeys(t *testing.T) {
+	_, err := ExtractNonce([]byte("cnonce=1256,nonces=1"))
 	assert.Error(t, err)
+	_, err = ExtractNonce([]byte("CNonce=1256,NONCES=1"))
+	assert.Error(t, err)
+}
+
+func TestExtractNonceKeyIsCaseInsensitive(t *testing.T) {
+	for _, challenge := range []string{"nonce=1256", "Nonce=1256", "NONCE=1256", "realm=x,NoNcE=1256"} {
+		actualNonce, err := ExtractNonce([]byte(challenge))
+		assert.NoError(t, err, challenge)
+		assert.Equal(t, "1256", actualNonce, challenge)
+	}
 }
 
 func TestComputeScope(t *testing.T) {
-	scope := computeScope(buildStdInstant(), "us-west-2")
+	scope := computeScope(buildStdInstant(), "us-west-2", "cassandra")
 	assert.Equal(t, "20200609/us-west-2/cassandra/aws4_request", scope)
 }
 
@@ -61,17 +161,196 @@ func TestFormCanonicalRequest(t *testing.T) {
 		"host\n" +
 		"ddf250111597b3f35e51e649f59e3f8b30ff5b247166d709dc1b1e60bd927070"
 
-	actual := formCanonicalRequest("UserID-1", scope, buildStdInstant(), nonce)
+	actual := Signer{}.formCanonicalRequest("UserID-1", scope, buildStdInstant(), nonce)
 	assert.Equal(t, canonicalRequest, actual)
 }
 
+func TestFormCanonicalRequestWithSignedHeaders(t *testing.T) {
+	scope := "20200609/us-west-2/cassandra/aws4_request"
+	canonicalRequest := "PUT\n" +
+		"/authenticate\n" +
+		"X-Amz-Algorithm=AWS4-HMAC-SHA256&X-Amz-Credential=UserID-1%2F20200609%2Fus-west-2%2Fcassandra%2Faws4_request&X-Amz-Date=2020-06-09T22%3A41%3A51.000Z&X-Amz-Expires=900\n" +
+		"host:cassandra\n" +
+		"x-amz-client:gocql plugin\n" +
+		"x-amz-protocol:2\n\n" +
+		"host;x-amz-client;x-amz-protocol\n" +
+		"ddf250111597b3f35e51e649f59e3f8b30ff5b247166d709dc1b1e60bd927070"
+	signer := Signer{SignedHeaders: map[string]string{
+		"X-Amz-Protocol": "2",
+		"x-amz-client":   "  gocql   plugin ",
+		"Host":           "ignored"}}
+
+	actual := signer.formCanonicalRequest("UserID-1", scope, buildStdInstant(), nonce)
+	assert.Equal(t, canonicalRequest, actual)
+}
+
+func TestSignedHeadersChangeSignature(t *testing.T) {
+	expected := BuildSignedResponse(region, nonce, accessKeyId, secret, "", buildStdInstant())
+
+	assert.Equal(t, expected, Signer{SignedHeaders: map[string]string{}}.BuildSignedResponse(region, nonce, accessKeyId, secret, "", buildStdInstant()))
+	assert.NotEqual(t, expected, Signer{SignedHeaders: map[string]string{"x-amz-protocol": "2"}}.BuildSignedResponse(region, nonce, accessKeyId, secret, "", buildStdInstant()))
+}
+
+func TestSigningAlgorithmIsSwappable(t *testing.T) {
+	defer func(original signingAlgorithm) { algorithm = original }(algorithm)
+	algorithm = signingAlgorithm{name: "AWS4-HMAC-SHA512", newHash: sha512.New}
+
+	scope := "20200609/us-west-2/cassandra/aws4_request"
+	canonicalRequest := Signer{}.formCanonicalRequest(accessKeyId, scope, buildStdInstant(), nonce)
+	lines := strings.Split(canonicalRequest, "\n")
+	assert.True(t, strings.HasPrefix(lines[2], "X-Amz-Algorithm=AWS4-HMAC-SHA512&"))
+	assert.Len(t, lines[len(lines)-1], sha512.Size*2)
+	assert.Len(t, createSignature(canonicalRequest, buildStdInstant(), scope, []byte(secret)), sha512.Size)
+}
+
+func TestDescribeSigning(t *testing.T) {
+	scope, canonicalRequest := Signer{}.DescribeSigning(region, nonce, accessKeyId, buildStdInstant().In(time.FixedZone("PDT", -7*3600)))
+
+	assert.Equal(t, "20200609/us-west-2/cassandra/aws4_request", scope)
+	assert.Equal(t, Signer{}.formCanonicalRequest(accessKeyId, scope, buildStdInstant(), nonce), canonicalRequest)
+}
+
+func TestScopeOverride(t *testing.T) {
+	computed := Signer{ScopeOverride: "20200609/us-west-2/cassandra/aws4_request"}
+	assert.Equal(t, BuildSignedResponse(region, nonce, accessKeyId, secret, "", buildStdInstant()),
+		computed.BuildSignedResponse(region, nonce, accessKeyId, secret, "", buildStdInstant()))
+	assert.Equal(t, BuildSignedResponse(region, nonce, accessKeyId, secret, "", buildStdInstant()),
+		computed.BuildSignedResponseBytes(region, nonce, accessKeyId, []byte(secret), "", buildStdInstant()))
+
+	// signed exactly like the region and service the override names
+	staging := Signer{ScopeOverride: "20200609/eu-west-1/cassandra-staging/aws4_request"}
+	scope, canonicalRequest := staging.DescribeSigning(region, nonce, accessKeyId, buildStdInstant())
+	assert.Equal(t, "20200609/eu-west-1/cassandra-staging/aws4_request", scope)
+	assert.Contains(t, canonicalRequest, "X-Amz-Credential=UserID-1%2F20200609%2Feu-west-1%2Fcassandra-staging%2Faws4_request")
+	assert.Equal(t, Signer{Service: "cassandra-staging"}.Signature("eu-west-1", nonce, accessKeyId, secret, buildStdInstant()),
+		staging.Signature(region, nonce, accessKeyId, secret, buildStdInstant()))
+}
+
+func TestValidateScope(t *testing.T) {
+	assert.NoError(t, ValidateScope("20200609/us-west-2/cassandra/aws4_request"))
+	assert.EqualError(t, ValidateScope("20200609/us-west-2/cassandra"),
+		`credential scope "20200609/us-west-2/cassandra" is not of the form date/region/service/aws4_request`)
+	assert.EqualError(t, ValidateScope("20200609//cassandra/aws4_request"),
+		`credential scope "20200609//cassandra/aws4_request" has an empty part`)
+}
+
+func TestScopeInOtherPartitions(t *testing.T) {
+	for _, partitionRegion := range []string{"us-gov-west-1", "cn-north-1", "us-iso-east-1"} {
+		scope := computeScope(buildStdInstant(), partitionRegion, DefaultService)
+		assert.Equal(t, "20200609/"+partitionRegion+"/cassandra/aws4_request", scope)
+
+		canonicalRequest := Signer{}.formCanonicalRequest(accessKeyId, scope, buildStdInstant(), nonce)
+		assert.Contains(t, canonicalRequest, "%2F"+partitionRegion+"%2Fcassandra%2F")
+		assert.Contains(t, canonicalRequest, "\nhost:cassandra\n\nhost\n")
+	}
+}
+
+func TestFormCanonicalRequestWithExpires(t *testing.T) {
+	scope := "20200609/us-west-2/cassandra/aws4_request"
+
+	actual := Signer{ExpiresSeconds: 300}.formCanonicalRequest("UserID-1", scope, buildStdInstant(), nonce)
+	assert.Contains(t, actual, "&X-Amz-Expires=300\n")
+
+	actual = Signer{ExpiresSeconds: -1}.formCanonicalRequest("UserID-1", scope, buildStdInstant(), nonce)
+	assert.Contains(t, actual, "&X-Amz-Expires=900\n")
+}
+
+func TestBuildSignedResponseWithExpires(t *testing.T) {
+	expected := BuildSignedResponse(region, nonce, accessKeyId, secret, "", buildStdInstant())
+
+	assert.Equal(t, expected, Signer{ExpiresSeconds: DefaultExpiresSeconds}.BuildSignedResponse(region, nonce, accessKeyId, secret, "", buildStdInstant()))
+	assert.NotEqual(t, expected, Signer{ExpiresSeconds: 300}.BuildSignedResponse(region, nonce, accessKeyId, secret, "", buildStdInstant()))
+}
+
+func TestNonUTCTimeIsNormalized(t *testing.T) {
+	// 2020-06-09T22:41:51Z is already the next day in Tokyo
+	tokyo := buildStdInstant().In(time.FixedZone("JST", 9*60*60))
+
+	assert.Equal(t, "20200609/us-west-2/cassandra/aws4_request", Signer{}.Scope(tokyo, region))
+	assert.Equal(t, BuildSignedResponse(region, nonce, accessKeyId, secret, "", buildStdInstant()),
+		BuildSignedResponse(region, nonce, accessKeyId, secret, "", tokyo))
+	assert.Equal(t, Signer{}.Signature(region, nonce, accessKeyId, secret, buildStdInstant()),
+		Signer{}.Signature(region, nonce, accessKeyId, secret, tokyo))
+}
+
+func TestBuildSignedResponseWithService(t *testing.T) {
+	expected := BuildSignedResponse(region, nonce, accessKeyId, secret, "", buildStdInstant())
+
+	assert.Equal(t, expected, Signer{Service: DefaultService}.BuildSignedResponse(region, nonce, accessKeyId, secret, "", buildStdInstant()))
+	assert.NotEqual(t, expected, Signer{Service: "mock"}.BuildSignedResponse(region, nonce, accessKeyId, secret, "", buildStdInstant()))
+}
+
+func TestUriEncode(t *testing.T) {
+	assert.Equal(t, "UserID-1%2F20200609%2Fus-west-2%2Fcassandra%2Faws4_request", uriEncode("UserID-1/20200609/us-west-2/cassandra/aws4_request"))
+	assert.Equal(t, "2020-06-09T22%3A41%3A51.000Z", uriEncode("2020-06-09T22:41:51.000Z"))
+	assert.Equal(t, "a%20b%2Bc%3Dd~e_f.g%25%C3%A9", uriEncode("a b+c=d~e_f.g%é"))
+}
+
+func TestFormCanonicalRequestEncodesAccessKey(t *testing.T) {
+	scope := "20200609/us-west-2/cassandra/aws4_request"
+	canonicalRequest := Signer{}.formCanonicalRequest("Key/+= 1", scope, buildStdInstant(), nonce)
+
+	assert.Contains(t, canonicalRequest, "X-Amz-Credential=Key%2F%2B%3D%201%2F20200609%2Fus-west-2%2Fcassandra%2Faws4_request&")
+}
+
+func TestBuildSignedResponseWithSpecialCharacters(t *testing.T) {
+	// session tokens are base64, so commonly contain '/', '+' and '='. they are sent verbatim
+	// and not part of the signature
+	sessionToken := "FwoGZXIvYXdzE/+abc=="
+	actual := BuildSignedResponse(region, nonce, accessKeyId, secret, sessionToken, buildStdInstant())
+	assert.Equal(t, "signature=7f3691c18a81b8ce7457699effbfae5b09b4e0714ab38c1292dbdf082c9ddd87,"+
+		"access_key=UserID-1,amzdate=2020-06-09T22:41:51.000Z,session_token="+sessionToken, actual)
+
+	withSpecialKey := BuildSignedResponse(region, nonce, "Key/+=1", secret, "", buildStdInstant())
+	assert.Contains(t, withSpecialKey, ",access_key=Key/+=1,")
+}
+
+func TestFormCanonicalRequestWithHost(t *testing.T) {
+	scope := "20200609/us-west-2/cassandra/aws4_request"
+	canonicalRequest := Signer{Host: "localhost"}.formCanonicalRequest(accessKeyId, scope, buildStdInstant(), nonce)
+
+	assert.Contains(t, canonicalRequest, "\nhost:localhost\n\nhost\n")
+	assert.Equal(t, Signer{}.formCanonicalRequest(accessKeyId, scope, buildStdInstant(), nonce),
+		Signer{Host: DefaultHost}.formCanonicalRequest(accessKeyId, scope, buildStdInstant(), nonce))
+}
+
+func TestServiceNameInScopeAndSigningKey(t *testing.T) {
+	signer := Signer{Service: "mock"}
+	scope := computeScope(buildStdInstant(), region, signer.ServiceName())
+	assert.Equal(t, "20200609/us-west-2/mock/aws4_request", scope)
+
+	assert.NotEqual(t, deriveSigningKey(secret, buildStdInstant(), region, "cassandra"),
+		deriveSigningKey(secret, buildStdInstant(), region, signer.ServiceName()))
+}
+
 func TestDeriveSigningKey(t *testing.T) {
 	expected := "7fb139473f153aec1b05747b0cd5cd77a1186d22ae895a3a0128e699d72e1aba"
 
-	actual := deriveSigningKey(secret, buildStdInstant(), region)
+	actual := deriveSigningKey(secret, buildStdInstant(), region, "cassandra")
 	assert.Equal(t, expected, hex.EncodeToString(actual))
 }
 
+func TestDeriveSigningKeyBytesLeavesSecretToCaller(t *testing.T) {
+	secretBytes := []byte(secret)
+
+	actual := deriveSigningKeyBytes(secretBytes, buildStdInstant(), region, "cassandra")
+	assert.Equal(t, deriveSigningKey(secret, buildStdInstant(), region, "cassandra"), actual)
+	assert.Equal(t, secret, string(secretBytes))
+}
+
+func TestWipe(t *testing.T) {
+	b := []byte(secret)
+	Wipe(b)
+	assert.Equal(t, make([]byte, len(secret)), b)
+}
+
+func TestBuildSignedResponseBytes(t *testing.T) {
+	signer := Signer{}
+	expected := signer.BuildSignedResponse(region, nonce, accessKeyId, secret, "sess-token-1", buildStdInstant())
+
+	assert.Equal(t, expected, signer.BuildSignedResponseBytes(region, nonce, accessKeyId, []byte(secret), "sess-token-1", buildStdInstant()))
+}
+
 func TestCreateSignature(t *testing.T) {
 	signingKey, _ := hex.DecodeString("7fb139473f153aec1b05747b0cd5cd77a1186d22ae895a3a0128e699d72e1aba")
 	scope := "20200609/us-west-2/cassandra/aws4_request"
@@ -100,3 +379,159 @@ func TestBuildSignedResponseWithSessionToken(t *testing.T) {
 	expected := "signature=7f3691c18a81b8ce7457699effbfae5b09b4e0714ab38c1292dbdf082c9ddd87,access_key=UserID-1,amzdate=2020-06-09T22:41:51.000Z,session_token=sess-token-1"
 	assert.Equal(t, expected, actual)
 }
+
+// the response is comma separated, not a query string, and Amazon Keyspaces reads the token
+// verbatim, so the base64 characters of STS tokens must not be percent-encoded
+func TestBuildSignedResponseWithSTSSessionToken(t *testing.T) {
+	sessionToken := "IQoJb3JpZ2luX2VjEN7//////////wEaCXVzLXdlc3QtMiJHMEUCIQD+3x/kP9m+v2Lq==" +
+		"AiA5tXr/0n7+QpZ3Yq8W1sE+/uM2nT0kR5bJ4hV6cLg=="
+	actual := BuildSignedResponse(region, nonce, accessKeyId, secret, sessionToken, buildStdInstant())
+	expected := "signature=7f3691c18a81b8ce7457699effbfae5b09b4e0714ab38c1292dbdf082c9ddd87,access_key=UserID-1,amzdate=2020-06-09T22:41:51.000Z,session_token=" + sessionToken
+	assert.Equal(t, expected, actual)
+}
+
+func TestScopeAndDateAgreeBeforeMidnight(t *testing.T) {
+	instant, _ := time.Parse(time.RFC3339, "2020-06-09T23:59:59Z")
+
+	scope := computeScope(instant, region, "cassandra")
+	assert.Equal(t, "20200609/us-west-2/cassandra/aws4_request", scope)
+
+	canonicalRequest := Signer{}.formCanonicalRequest(accessKeyId, scope, instant, nonce)
+	assert.Contains(t, canonicalRequest, "X-Amz-Credential=UserID-1%2F20200609%2F")
+	assert.Contains(t, canonicalRequest, "X-Amz-Date=2020-06-09T23%3A59%3A59.000Z")
+
+	response := BuildSignedResponse(region, nonce, accessKeyId, secret, "", instant)
+	assert.Contains(t, response, "amzdate=2020-06-09T23:59:59.000Z")
+}
+
+func TestScopeAndDateAgreeAtMidnight(t *testing.T) {
+	instant, _ := time.Parse(time.RFC3339, "2020-06-10T00:00:00Z")
+
+	scope := computeScope(instant, region, "cassandra")
+	assert.Equal(t, "20200610/us-west-2/cassandra/aws4_request", scope)
+
+	canonicalRequest := Signer{}.formCanonicalRequest(accessKeyId, scope, instant, nonce)
+	assert.Contains(t, canonicalRequest, "X-Amz-Credential=UserID-1%2F20200610%2F")
+	assert.Contains(t, canonicalRequest, "X-Amz-Date=2020-06-10T00%3A00%3A00.000Z")
+
+	response := BuildSignedResponse(region, nonce, accessKeyId, secret, "", instant)
+	assert.Contains(t, response, "amzdate=2020-06-10T00:00:00.000Z")
+}
+
+func TestDateStampAtMillisecondBoundary(t *testing.T) {
+	instants := map[string]string{
+		"2020-06-09T23:59:59.999Z": "20200609/us-west-2/cassandra/aws4_request",
+		"2020-06-10T00:00:00.000Z": "20200610/us-west-2/cassandra/aws4_request",
+	}
+	for amzDate, expectedScope := range instants {
+		instant, _ := time.Parse(time.RFC3339Nano, amzDate)
+
+		scope, canonicalRequest := Signer{}.DescribeSigning(region, nonce, accessKeyId, instant)
+		assert.Equal(t, expectedScope, scope, amzDate)
+		assert.Contains(t, canonicalRequest, "X-Amz-Date="+uriEncode(amzDate), amzDate)
+		assert.Contains(t, BuildSignedResponse(region, nonce, accessKeyId, secret, "", instant), "amzdate="+amzDate, amzDate)
+	}
+}
+
+// the canonical request, the string to sign and the response each format the signing time,
+// a sub-millisecond instant must be truncated identically in all three
+func TestAmzDateAgreesAcrossSigningSteps(t *testing.T) {
+	instant := time.Date(2020, 6, 9, 22, 41, 51, 123456789, time.UTC)
+	expected := "2020-06-09T22:41:51.123Z"
+	assert.Equal(t, expected, instant.Format(AmzDateFormat))
+
+	scope, canonicalRequest := Signer{}.DescribeSigning(region, nonce, accessKeyId, instant)
+	assert.Contains(t, canonicalRequest, "X-Amz-Date="+uriEncode(expected))
+	assert.Equal(t, expected, strings.Split(stringToSign(canonicalRequest, instant, scope), "\n")[1])
+	assert.Contains(t, BuildSignedResponse(region, nonce, accessKeyId, secret, "", instant), "amzdate="+expected)
+}
+
+// go's time formatting is locale independent, so only the zone could change the output.
+// neither the local zone nor the instant's own zone may affect the signature.
+func TestSigningIndependentOfTimeZone(t *testing.T) {
+	defer func(local *time.Location) { time.Local = local }(time.Local)
+
+	for _, amzDate := range []string{"2020-06-09T23:59:59.999Z", "2020-06-10T00:00:00.000Z"} {
+		instant, _ := time.Parse(time.RFC3339Nano, amzDate)
+		expected := BuildSignedResponse(region, nonce, accessKeyId, secret, "", instant)
+
+		for _, zone := range []*time.Location{
+			time.UTC,
+			time.FixedZone("PDT", -7*3600),
+			time.FixedZone("JST", 9*3600),
+			time.FixedZone("LINT", 14*3600),
+			time.FixedZone("AoE", -12*3600),
+			time.FixedZone("NPT", 5*3600+45*60),
+		} {
+			time.Local = zone
+			assert.Equal(t, expected, BuildSignedResponse(region, nonce, accessKeyId, secret, "", instant), zone.String())
+			assert.Equal(t, expected, BuildSignedResponse(region, nonce, accessKeyId, secret, "", instant.In(zone)), zone.String())
+			assert.Equal(t, expected, BuildSignedResponse(region, nonce, accessKeyId, secret, "", instant.Local()), zone.String())
+		}
+	}
+}
+
+func TestSigningKeyChangesAtMidnight(t *testing.T) {
+	before, _ := time.Parse(time.RFC3339, "2020-06-09T23:59:59Z")
+	after, _ := time.Parse(time.RFC3339, "2020-06-10T00:00:00Z")
+
+	assert.Equal(t, deriveSigningKey(secret, buildStdInstant(), region, "cassandra"), deriveSigningKey(secret, before, region, "cassandra"))
+	assert.NotEqual(t, deriveSigningKey(secret, before, region, "cassandra"), deriveSigningKey(secret, after, region, "cassandra"))
+}
+
+// secret access key of the examples in the AWS SigV4 documentation and test suite
+const awsExampleSecret = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+
+// the key derivation example of the AWS SigV4 documentation, "Examples of how to derive a
+// signing key for Signature Version 4", including the intermediate keys
+func TestDeriveSigningKeyMatchesAWSExample(t *testing.T) {
+	instant := time.Date(2012, 2, 15, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, "f4780e2d9f65fa895f9c67b32ce1baf0b0d8a43505a000a1a9e090d414db404d",
+		hex.EncodeToString(deriveSigningKey(awsExampleSecret, instant, "us-east-1", "iam")))
+
+	kDate := applyHmac("20120215", []byte("AWS4"+awsExampleSecret))
+	assert.Equal(t, "969fbb94feb542b71ede6f87fe4d5fa29c789342b0f407474670f0c2489e0a0d", hex.EncodeToString(kDate))
+	kRegion := applyHmac("us-east-1", kDate)
+	assert.Equal(t, "69daa0209cd9c5ff5c8ced464a696fd4252e981430b10e3d3fd8e2f197d7a70c", hex.EncodeToString(kRegion))
+	kService := applyHmac("iam", kRegion)
+	assert.Equal(t, "f72cfd46f26bc4643f06a11eabb6c0ba18780c19a8da0c31ace671265e3c87fa", hex.EncodeToString(kService))
+}
+
+// the get-vanilla case of the AWS SigV4 test suite. its canonical request and string to sign
+// use the standard layout, so they are given verbatim and only hashing and signing are ours.
+func TestSignatureMatchesAWSTestSuite(t *testing.T) {
+	canonicalRequest := "GET\n/\n\nhost:example.amazonaws.com\nx-amz-date:20150830T123600Z\n\nhost;x-amz-date\n" +
+		"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	hashedRequest := algorithm.hexDigest(canonicalRequest)
+	assert.Equal(t, "bb579772317eb040ac9ed261061d46c1f17a8133879d6129b6e1c25292927e63", hashedRequest)
+
+	signingKey := deriveSigningKey(awsExampleSecret, time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC), "us-east-1", "service")
+	stringToSign := "AWS4-HMAC-SHA256\n20150830T123600Z\n20150830/us-east-1/service/aws4_request\n" + hashedRequest
+	assert.Equal(t, "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		hex.EncodeToString(applyHmac(stringToSign, signingKey)))
+}
+
+func BenchmarkDeriveSigningKey(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		deriveSigningKey(secret, buildStdInstant(), region, "cassandra")
+	}
+}
+
+// signing keys come from the cache after the first iteration
+func BenchmarkBuildSignedResponse(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		BuildSignedResponse(region, nonce, accessKeyId, secret, "", buildStdInstant())
+	}
+}
+
+// derives the signing key on every iteration, the difference to BuildSignedResponse is the
+// benefit of the signing key cache
+func BenchmarkBuildSignedResponseUncached(b *testing.B) {
+	b.ReportAllocs()
+	secretBytes := []byte(secret)
+	for i := 0; i < b.N; i++ {
+		Signer{}.BuildSignedResponseBytes(region, nonce, accessKeyId, secretBytes, "", buildStdInstant())
+	}
+}
@@ -0,0 +1,39 @@
+//go:build go1.24
+// +build go1.24
+
+/*
+ *  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package internal
+
+import (
+	"crypto/fips140"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// signing only uses crypto/hmac and crypto/sha256, which the Go Cryptographic Module serves in
+// FIPS 140-3 mode. run with GODEBUG=fips140=on, or fips140=only to reject unapproved algorithms.
+func TestBuildSignedResponseInFIPSMode(t *testing.T) {
+	if !fips140.Enabled() {
+		t.Skip("FIPS 140-3 mode not enabled, set GODEBUG=fips140=on")
+	}
+
+	actual := BuildSignedResponse(region, nonce, accessKeyId, secret, "", buildStdInstant())
+	expected := "signature=7f3691c18a81b8ce7457699effbfae5b09b4e0714ab38c1292dbdf082c9ddd87,access_key=UserID-1,amzdate=2020-06-09T22:41:51.000Z"
+	assert.Equal(t, expected, actual)
+}
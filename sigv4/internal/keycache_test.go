@@ -0,0 +1,72 @@
+/*
+ *  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package internal
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSigningKeyCacheMatchesDerivedKey(t *testing.T) {
+	cache := newSigningKeyCache()
+	nextDay := buildStdInstant().Add(24 * time.Hour)
+
+	assert.Equal(t, deriveSigningKey(secret, buildStdInstant(), region, "cassandra"), cache.get(secret, buildStdInstant(), region, "cassandra"))
+	assert.Equal(t, deriveSigningKey(secret, buildStdInstant(), region, "cassandra"), cache.get(secret, buildStdInstant(), region, "cassandra"))
+	assert.Equal(t, deriveSigningKey(secret, nextDay, region, "cassandra"), cache.get(secret, nextDay, region, "cassandra"))
+	assert.Equal(t, deriveSigningKey(secret, nextDay, "us-east-1", "cassandra"), cache.get(secret, nextDay, "us-east-1", "cassandra"))
+	assert.Equal(t, deriveSigningKey("OtherSecret", nextDay, "us-east-1", "cassandra"), cache.get("OtherSecret", nextDay, "us-east-1", "cassandra"))
+	assert.Len(t, cache.keys, 4)
+}
+
+func TestSigningKeyCacheEvictsStaleDays(t *testing.T) {
+	cache := newSigningKeyCache()
+	for i := 0; i < maxCachedSigningKeys; i++ {
+		cache.get(fmt.Sprintf("secret-%d", i), buildStdInstant(), region, "cassandra")
+	}
+	assert.Len(t, cache.keys, maxCachedSigningKeys)
+
+	cache.get(secret, buildStdInstant().Add(24*time.Hour), region, "cassandra")
+	assert.Len(t, cache.keys, 1)
+}
+
+func TestSigningKeyCacheIsBounded(t *testing.T) {
+	cache := newSigningKeyCache()
+	for i := 0; i < 2*maxCachedSigningKeys; i++ {
+		cache.get(fmt.Sprintf("secret-%d", i), buildStdInstant(), region, "cassandra")
+		assert.True(t, len(cache.keys) <= maxCachedSigningKeys)
+	}
+}
+
+func TestSigningKeyCacheConcurrentUse(t *testing.T) {
+	cache := newSigningKeyCache()
+	expected := deriveSigningKey(secret, buildStdInstant(), region, "cassandra")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Equal(t, expected, cache.get(secret, buildStdInstant(), region, "cassandra"))
+		}()
+	}
+	wg.Wait()
+}
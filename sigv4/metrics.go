@@ -0,0 +1,45 @@
+/*
+ *  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package sigv4
+
+// Counts events of the handshake, e.g. to export them as Prometheus counters. Inc is called
+// with one of the Metric constants and must be safe for concurrent use.
+type Metrics interface {
+	Inc(counter string)
+}
+
+// counters passed to Metrics.Inc
+const (
+	MetricChallengesSigned            = "sigv4_challenges_signed"
+	MetricCredentialRetrievals        = "sigv4_credential_retrievals"
+	MetricCredentialRetrievalFailures = "sigv4_credential_retrieval_failures"
+	MetricNonceExtractionFailures     = "sigv4_nonce_extraction_failures"
+	MetricBackgroundRefreshFailures   = "sigv4_background_refresh_failures"
+)
+
+// counts the events of every handshake in metrics
+func WithMetrics(metrics Metrics) Option {
+	return func(auth *AwsAuthenticator) {
+		auth.Metrics = metrics
+	}
+}
+
+func (p signingAuthenticator) inc(counter string) {
+	if p.metrics != nil {
+		p.metrics.Inc(counter)
+	}
+}
@@ -0,0 +1,73 @@
+/*
+ *  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package sigv4
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type countingMetrics struct {
+	mu       sync.Mutex
+	counters map[string]int
+}
+
+func (m *countingMetrics) Inc(counter string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.counters == nil {
+		m.counters = map[string]int{}
+	}
+	m.counters[counter]++
+}
+
+func TestMetrics(t *testing.T) {
+	metrics := &countingMetrics{}
+	failing := false
+	target := NewAwsAuthenticatorWithCredentialCallback("us-west-2", func() (SigV4Credentials, error) {
+		if failing {
+			return SigV4Credentials{}, errors.New("bad error")
+		}
+		return SigV4Credentials{AccessKeyId: "UserID-1", SecretAccessKey: "UserSecretKey-1"}, nil
+	}, WithMetrics(metrics), WithClock(stdClock))
+
+	_, challenger, _ := target.Challenge(nil)
+	challenger.Challenge(stdNonce)
+	challenger.Challenge([]byte("unexpected"))
+	failing = true
+	challenger.Challenge(stdNonce)
+
+	assert.Equal(t, map[string]int{
+		MetricChallengesSigned:            1,
+		MetricCredentialRetrievals:        2,
+		MetricCredentialRetrievalFailures: 1,
+		MetricNonceExtractionFailures:     1}, metrics.counters)
+}
+
+func TestStaticCredentialsAreNotCountedAsRetrievals(t *testing.T) {
+	metrics := &countingMetrics{}
+	target := buildStdTarget()
+	WithMetrics(metrics)(target)
+
+	_, challenger, _ := target.Challenge(nil)
+	challenger.Challenge(stdNonce)
+
+	assert.Equal(t, map[string]int{MetricChallengesSigned: 1}, metrics.counters)
+}
@@ -0,0 +1,80 @@
+/*
+ *  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package sigv4
+
+import (
+	"time"
+)
+
+// Receives the outcome of each step of the handshake, laid out like gocql's observers, e.g. to
+// report authentication next to the connection metrics of a gocql.ConnectObserver. gocql already
+// reports failed handshakes to its ConnectObserver as failed connects, so an AuthObserver is the
+// place for the step timings and the access key id signed with. ObserveAuth must be safe for
+// concurrent use.
+type AuthObserver interface {
+	ObserveAuth(ObservedAuth)
+}
+
+// handshake steps reported in ObservedAuth.Event
+const (
+	AuthEventInitialResponse = "initial_response" // the server requested authentication, SigV4 was selected
+	AuthEventChallenge       = "challenge"        // a nonce challenge was received and, without Err, the signature sent
+	AuthEventSuccess         = "success"          // the server accepted the signature
+)
+
+type ObservedAuth struct {
+	Event       string // one of the AuthEvent constants
+	Region      string
+	AccessKeyId string // the access key id signed with, set for successful challenges only
+
+	Start time.Time // time the step started
+	End   time.Time // time the step completed
+
+	// Err is the error the step failed with (if any), which fails the connection
+	Err error
+}
+
+// reports each handshake step to observer
+func WithAuthObserver(observer AuthObserver) Option {
+	return func(auth *AwsAuthenticator) {
+		auth.Observer = observer
+	}
+}
+
+// starts observing a handshake step, a no-op when no observer is set
+func startObservation(observer AuthObserver, event string, region string) func(accessKeyId string, err error) {
+	if observer == nil {
+		return func(string, error) {}
+	}
+	start := time.Now()
+	return func(accessKeyId string, err error) {
+		observer.ObserveAuth(ObservedAuth{
+			Event:       event,
+			Region:      region,
+			AccessKeyId: accessKeyId,
+			Start:       start,
+			End:         time.Now(),
+			Err:         err})
+	}
+}
+
+// adapts a func to an AuthObserver, like http.HandlerFunc
+type AuthObserverFunc func(ObservedAuth)
+
+func (f AuthObserverFunc) ObserveAuth(observed ObservedAuth) {
+	f(observed)
+}
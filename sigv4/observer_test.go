@@ -0,0 +1,86 @@
+/*
+ *  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package sigv4
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingAuthObserver struct {
+	observed []ObservedAuth
+}
+
+func (o *recordingAuthObserver) ObserveAuth(observed ObservedAuth) {
+	o.observed = append(o.observed, observed)
+}
+
+func TestAuthObserver(t *testing.T) {
+	observer := &recordingAuthObserver{}
+	target := buildStdTarget()
+	WithAuthObserver(observer)(target)
+
+	_, challenger, _ := target.Challenge(nil)
+	_, challenger, err := challenger.Challenge(stdNonce)
+	assert.NoError(t, err)
+	assert.NoError(t, challenger.Success(nil))
+
+	if assert.Len(t, observer.observed, 3) {
+		assert.Equal(t, AuthEventInitialResponse, observer.observed[0].Event)
+		assert.Equal(t, AuthEventChallenge, observer.observed[1].Event)
+		assert.Equal(t, "UserID-1", observer.observed[1].AccessKeyId)
+		assert.Equal(t, AuthEventSuccess, observer.observed[2].Event)
+		for _, observed := range observer.observed {
+			assert.Equal(t, "us-west-2", observed.Region)
+			assert.NoError(t, observed.Err)
+			assert.False(t, observed.End.Before(observed.Start))
+		}
+	}
+}
+
+func TestAuthObserverRecordsFailure(t *testing.T) {
+	observer := &recordingAuthObserver{}
+	target := NewAwsAuthenticatorWithCredentialCallback("us-west-2", func() (SigV4Credentials, error) {
+		return SigV4Credentials{}, errors.New("bad error")
+	}, WithAuthObserver(observer))
+
+	_, challenger, _ := target.Challenge(nil)
+	_, _, err := challenger.Challenge(stdNonce)
+	assert.Error(t, err)
+
+	if assert.Len(t, observer.observed, 2) {
+		assert.Equal(t, AuthEventChallenge, observer.observed[1].Event)
+		assert.Equal(t, err, observer.observed[1].Err)
+		assert.Empty(t, observer.observed[1].AccessKeyId)
+	}
+}
+
+func TestAuthObserverFunc(t *testing.T) {
+	var events []string
+	target := buildStdTarget()
+	WithAuthObserver(AuthObserverFunc(func(observed ObservedAuth) {
+		events = append(events, observed.Event)
+	}))(target)
+
+	_, challenger, _ := target.Challenge(nil)
+	_, challenger, _ = challenger.Challenge(stdNonce)
+	challenger.Success(nil)
+
+	assert.Equal(t, []string{AuthEventInitialResponse, AuthEventChallenge, AuthEventSuccess}, events)
+}
@@ -0,0 +1,214 @@
+/*
+ *  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package sigv4
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/ec2rolecreds"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/defaults"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+)
+
+// adapts AWS SDK credentials to a CredentialProvider. the SDK caches the retrieved
+// credentials and refreshes them shortly before they expire, so retrieving on every
+// challenge is cheap and always returns current credentials.
+type sdkCredentialProvider struct {
+	creds *credentials.Credentials
+}
+
+func (p sdkCredentialProvider) Retrieve(ctx context.Context) (SigV4Credentials, error) {
+	value, err := p.creds.GetWithContext(ctx)
+	if err != nil {
+		return SigV4Credentials{}, err
+	}
+
+	// trimmed like the values loaded by the default constructors
+	result := SigV4Credentials{
+		AccessKeyId:     strings.TrimSpace(value.AccessKeyID),
+		SecretAccessKey: strings.TrimSpace(value.SecretAccessKey),
+		SessionToken:    strings.TrimSpace(value.SessionToken)}
+	// not every SDK provider tracks expiry
+	if expiration, err := p.creds.ExpiresAt(); err == nil {
+		result.Expiration = expiration
+	}
+	return result, nil
+}
+
+// initializes authenticator with an AWS SDK credentials provider, e.g. one of the SDK's own or a
+// custom implementation. credentials are retrieved again only once provider.IsExpired reports
+// them as expired, and are safe to share across connections.
+func NewAwsAuthenticatorWithProvider(region string, provider credentials.Provider, opts ...Option) AwsAuthenticator {
+	return NewAwsAuthenticatorWithCredentialProvider(region, sdkCredentialProvider{credentials.NewCredentials(provider)}, opts...)
+}
+
+// initializes authenticator from SDK credentials, retrieving them once so that
+// misconfiguration is reported at construction rather than at the first connection.
+func newAwsAuthenticatorFromSDKCredentials(region string, creds *credentials.Credentials, opts []Option) (AwsAuthenticator, error) {
+	// STS is called in region, so check it before the first retrieval
+	if err := validateRegion(region); err != nil {
+		return AwsAuthenticator{}, err
+	}
+
+	provider := sdkCredentialProvider{creds}
+	if _, err := provider.Retrieve(context.Background()); err != nil {
+		return AwsAuthenticator{}, fmt.Errorf("failed to retrieve AWS credentials: %w", err)
+	}
+	return withValidRegion(NewAwsAuthenticatorWithCredentialProvider(region, provider, opts...), nil)
+}
+
+// calls STS through client in NewAwsAuthenticatorWithAssumeRole and
+// NewAwsAuthenticatorFromWebIdentity instead of a client the plugin creates, e.g. an *sts.STS
+// configured with a regional or VPC endpoint, retries or an HTTP client. it has no effect on
+// the other constructors.
+func WithSTSClient(client stsiface.STSAPI) Option {
+	return func(auth *AwsAuthenticator) {
+		auth.stsClient = client
+	}
+}
+
+// returns the client set by WithSTSClient, otherwise a client of a new session in region
+func stsClientFromOptions(region string, opts []Option) (stsiface.STSAPI, error) {
+	if client := applyOptions(AwsAuthenticator{}, opts).stsClient; client != nil {
+		return client, nil
+	}
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AWS session: %w", err)
+	}
+	return sts.New(sess), nil
+}
+
+// initializes authenticator with credentials obtained by assuming roleArn with the web
+// identity token in tokenFile, as used by IAM Roles for Service Accounts on EKS. the token
+// file is re-read on each refresh, so rotated service account tokens are picked up.
+func NewAwsAuthenticatorFromWebIdentity(region string, roleArn string, tokenFile string, opts ...Option) (AwsAuthenticator, error) {
+	client, err := stsClientFromOptions(region, opts)
+	if err != nil {
+		return AwsAuthenticator{}, err
+	}
+
+	// an empty session name lets the SDK generate one per refresh
+	creds := credentials.NewCredentials(stscreds.NewWebIdentityRoleProvider(client, roleArn, "", tokenFile))
+	return newAwsAuthenticatorFromSDKCredentials(region, creds, opts)
+}
+
+// initializes authenticator with credentials obtained by assuming roleArn, e.g. a role in
+// another account, using the default credential provider chain as the source identity.
+// externalId is optional and only sent when not empty. a zero sessionDuration uses the
+// STS default. the temporary credentials are refreshed automatically before they expire.
+func NewAwsAuthenticatorWithAssumeRole(region string, roleArn string, externalId string, sessionDuration time.Duration, opts ...Option) (AwsAuthenticator, error) {
+	client, err := stsClientFromOptions(region, opts)
+	if err != nil {
+		return AwsAuthenticator{}, err
+	}
+
+	creds := stscreds.NewCredentialsWithClient(client, roleArn, func(p *stscreds.AssumeRoleProvider) {
+		if sessionDuration > 0 {
+			p.Duration = sessionDuration
+		}
+		if externalId != "" {
+			p.ExternalID = aws.String(externalId)
+		}
+	})
+	return newAwsAuthenticatorFromSDKCredentials(region, creds, opts)
+}
+
+// initializes authenticator with the container credentials of an ECS task or Fargate, skipping
+// the other providers of the default chain and their timeouts, e.g. probing IMDS.
+// the endpoint is taken from AWS_CONTAINER_CREDENTIALS_FULL_URI or
+// AWS_CONTAINER_CREDENTIALS_RELATIVE_URI, which ECS sets, and an error is returned when
+// neither is set. the credentials are refreshed automatically before they expire.
+func NewAwsAuthenticatorFromECSCredentials(region string, opts ...Option) (AwsAuthenticator, error) {
+	if os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI") == "" && os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI") == "" {
+		return AwsAuthenticator{}, errors.New("no container credentials endpoint, " +
+			"neither AWS_CONTAINER_CREDENTIALS_FULL_URI nor AWS_CONTAINER_CREDENTIALS_RELATIVE_URI is set")
+	}
+
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		return AwsAuthenticator{}, fmt.Errorf("failed to create AWS session: %w", err)
+	}
+
+	// with one of the variables set this is the container provider, including the
+	// authorization token handling, and never the EC2 instance role
+	creds := credentials.NewCredentials(defaults.RemoteCredProvider(*sess.Config, sess.Handlers))
+	return newAwsAuthenticatorFromSDKCredentials(region, creds, opts)
+}
+
+// one of the sources of the default credential provider chain, for
+// NewAwsAuthenticatorWithCredentialOrder
+type CredentialSource string
+
+const (
+	// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN
+	CredentialSourceEnvironment CredentialSource = "environment"
+	// the AWS_PROFILE, or default, profile of the shared credentials file
+	CredentialSourceSharedCredentials CredentialSource = "shared_credentials"
+	// the ECS task or Fargate credentials endpoint, skipped when ECS has not set one
+	CredentialSourceContainer CredentialSource = "container"
+	// the EC2 instance role, from the instance metadata service
+	CredentialSourceInstanceRole CredentialSource = "instance_role"
+)
+
+// initializes authenticator with credentials from the first of sources providing them, tried
+// in the given order instead of the default chain's, e.g. to prefer the instance role over
+// stale keys in a shared credentials file. sources left out are never consulted. the first
+// retrieval happens here, so an error is returned when no source provides credentials.
+// a custom chain, including providers of the AWS SDK not listed here, can be passed to
+// NewAwsAuthenticatorWithProvider as a credentials.ChainProvider.
+func NewAwsAuthenticatorWithCredentialOrder(region string, sources []CredentialSource, opts ...Option) (AwsAuthenticator, error) {
+	if len(sources) == 0 {
+		return AwsAuthenticator{}, errors.New("no credential sources given")
+	}
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		return AwsAuthenticator{}, fmt.Errorf("failed to create AWS session: %w", err)
+	}
+
+	providers := make([]credentials.Provider, 0, len(sources))
+	for _, source := range sources {
+		switch source {
+		case CredentialSourceEnvironment:
+			providers = append(providers, &credentials.EnvProvider{})
+		case CredentialSourceSharedCredentials:
+			providers = append(providers, &credentials.SharedCredentialsProvider{})
+		case CredentialSourceContainer:
+			// without either variable RemoteCredProvider would fall back to the instance role
+			if os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI") != "" || os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI") != "" {
+				providers = append(providers, defaults.RemoteCredProvider(*sess.Config, sess.Handlers))
+			}
+		case CredentialSourceInstanceRole:
+			providers = append(providers, &ec2rolecreds.EC2RoleProvider{Client: ec2metadata.New(sess)})
+		default:
+			return AwsAuthenticator{}, fmt.Errorf("unknown credential source %q", source)
+		}
+	}
+	chain := &credentials.ChainProvider{Providers: providers, VerboseErrors: true}
+	return newAwsAuthenticatorFromSDKCredentials(region, credentials.NewCredentials(chain), opts)
+}
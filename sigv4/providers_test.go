@@ -0,0 +1,275 @@
+/*
+ *  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package sigv4
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client/metadata"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSDKCredentialProvider(t *testing.T) {
+	provider := sdkCredentialProvider{credentials.NewStaticCredentials("UserID-1", "UserSecretKey-1", "sess-token-1")}
+
+	credentials, err := provider.Retrieve(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, SigV4Credentials{
+		AccessKeyId:     "UserID-1",
+		SecretAccessKey: "UserSecretKey-1",
+		SessionToken:    "sess-token-1"}, credentials)
+}
+
+func TestNewAwsAuthenticatorFromSDKCredentials(t *testing.T) {
+	creds := credentials.NewStaticCredentials("UserID-1", "UserSecretKey-1", "")
+
+	target, err := newAwsAuthenticatorFromSDKCredentials("us-west-2", creds, nil)
+	assert.NoError(t, err)
+	target.Clock = stdClock
+
+	_, challenger, _ := target.Challenge(nil)
+
+	resp, _, _ := challenger.Challenge(stdNonce)
+	expected := "signature=7f3691c18a81b8ce7457699effbfae5b09b4e0714ab38c1292dbdf082c9ddd87,access_key=UserID-1,amzdate=2020-06-09T22:41:51.000Z"
+	assert.Equal(t, expected, string(resp))
+}
+
+// hands out numbered credentials, expiring when told to
+type expiringProvider struct {
+	retrievals int
+	expired    bool
+}
+
+func (p *expiringProvider) Retrieve() (credentials.Value, error) {
+	p.retrievals++
+	p.expired = false
+	return credentials.Value{
+		AccessKeyID:     fmt.Sprintf("UserID-%d", p.retrievals),
+		SecretAccessKey: "UserSecretKey-1"}, nil
+}
+
+func (p *expiringProvider) IsExpired() bool {
+	return p.expired
+}
+
+func TestNewAwsAuthenticatorWithProvider(t *testing.T) {
+	provider := &expiringProvider{}
+	target := NewAwsAuthenticatorWithProvider("us-west-2", provider)
+	target.Clock = stdClock
+
+	for i := 0; i < 2; i++ {
+		_, challenger, _ := target.Challenge(nil)
+		resp, _, _ := challenger.Challenge(stdNonce)
+		assert.Contains(t, string(resp), "access_key=UserID-1,")
+	}
+	assert.Equal(t, 1, provider.retrievals)
+
+	provider.expired = true
+	_, challenger, _ := target.Challenge(nil)
+	resp, _, _ := challenger.Challenge(stdNonce)
+	assert.Contains(t, string(resp), "access_key=UserID-2,")
+}
+
+func TestNewAwsAuthenticatorFromWebIdentityMissingToken(t *testing.T) {
+	_, err := NewAwsAuthenticatorFromWebIdentity("us-west-2", "arn:aws:iam::123456789012:role/keyspaces", "/nonexistent/token")
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to retrieve AWS credentials")
+}
+
+// answers AssumeRoleWithWebIdentity without calling STS, recording the token each call sent and
+// rotating the token file afterwards as the kubelet does
+type fakeWebIdentitySTSClient struct {
+	stsiface.STSAPI
+	tokenFile string
+	tokens    []string
+}
+
+// the SDK's web identity provider builds the request itself, so the output is filled in up front
+// and the request is sent without any handlers
+func (c *fakeWebIdentitySTSClient) AssumeRoleWithWebIdentityRequest(input *sts.AssumeRoleWithWebIdentityInput) (*request.Request, *sts.AssumeRoleWithWebIdentityOutput) {
+	c.tokens = append(c.tokens, aws.StringValue(input.WebIdentityToken))
+	ioutil.WriteFile(c.tokenFile, []byte(fmt.Sprintf("token-%d", len(c.tokens)+1)), 0600)
+
+	output := &sts.AssumeRoleWithWebIdentityOutput{Credentials: &sts.Credentials{
+		AccessKeyId:     aws.String(fmt.Sprintf("UserID-%d", len(c.tokens))),
+		SecretAccessKey: aws.String("UserSecretKey-1"),
+		SessionToken:    aws.String("sess-token-1"),
+		Expiration:      aws.Time(time.Now().Add(time.Hour))}}
+	req := request.New(aws.Config{}, metadata.ClientInfo{Endpoint: "https://sts.amazonaws.com"}, request.Handlers{},
+		nil, &request.Operation{Name: "AssumeRoleWithWebIdentity", HTTPMethod: "POST", HTTPPath: "/"}, input, output)
+	return req, output
+}
+
+func TestNewAwsAuthenticatorFromWebIdentityRereadsRotatedToken(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "sigv4")
+	defer os.RemoveAll(dir)
+	tokenFile := dir + "/token"
+	ioutil.WriteFile(tokenFile, []byte("token-1"), 0600)
+	client := &fakeWebIdentitySTSClient{tokenFile: tokenFile}
+
+	target, err := NewAwsAuthenticatorFromWebIdentity("us-west-2", "arn:aws:iam::123456789012:role/keyspaces", tokenFile,
+		WithSTSClient(client))
+	assert.NoError(t, err)
+	credentials, err := target.credentialProvider().Retrieve(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "UserID-1", credentials.AccessKeyId)
+	assert.Equal(t, []string{"token-1"}, client.tokens)
+
+	// the credentials reaching their expiration makes the next challenge refresh them
+	target.CredentialProvider.(sdkCredentialProvider).creds.Expire()
+	credentials, err = target.credentialProvider().Retrieve(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "UserID-2", credentials.AccessKeyId)
+	assert.Equal(t, []string{"token-1", "token-2"}, client.tokens)
+}
+
+func TestNewAwsAuthenticatorWithAssumeRoleWithoutSourceCredentials(t *testing.T) {
+	defer disableDefaultCredentialChain()()
+
+	_, err := NewAwsAuthenticatorWithAssumeRole("us-west-2", "arn:aws:iam::123456789012:role/keyspaces", "external-1", time.Hour)
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to retrieve AWS credentials")
+}
+
+// answers AssumeRole without calling STS, the other methods are not implemented
+type fakeSTSClient struct {
+	stsiface.STSAPI
+	inputs []*sts.AssumeRoleInput
+}
+
+func (c *fakeSTSClient) AssumeRoleWithContext(ctx aws.Context, input *sts.AssumeRoleInput, opts ...request.Option) (*sts.AssumeRoleOutput, error) {
+	c.inputs = append(c.inputs, input)
+	return &sts.AssumeRoleOutput{Credentials: &sts.Credentials{
+		AccessKeyId:     aws.String("UserID-1"),
+		SecretAccessKey: aws.String("UserSecretKey-1"),
+		SessionToken:    aws.String("sess-token-1"),
+		Expiration:      aws.Time(time.Now().Add(time.Hour))}}, nil
+}
+
+func TestNewAwsAuthenticatorWithAssumeRoleUsesSTSClient(t *testing.T) {
+	defer disableDefaultCredentialChain()()
+	client := &fakeSTSClient{}
+
+	target, err := NewAwsAuthenticatorWithAssumeRole("us-west-2", "arn:aws:iam::123456789012:role/keyspaces", "external-1", time.Hour,
+		WithSTSClient(client))
+
+	assert.NoError(t, err)
+	credentials, err := target.credentialProvider().Retrieve(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "UserID-1", credentials.AccessKeyId)
+	assert.Equal(t, "sess-token-1", credentials.SessionToken)
+	if assert.Len(t, client.inputs, 1) {
+		assert.Equal(t, "arn:aws:iam::123456789012:role/keyspaces", aws.StringValue(client.inputs[0].RoleArn))
+		assert.Equal(t, "external-1", aws.StringValue(client.inputs[0].ExternalId))
+		assert.Equal(t, int64(3600), aws.Int64Value(client.inputs[0].DurationSeconds))
+	}
+}
+
+func TestNewAwsAuthenticatorFromECSCredentials(t *testing.T) {
+	expiration := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "token-1", r.Header.Get("Authorization"))
+		fmt.Fprintf(w, `{"AccessKeyId":"UserID-1","SecretAccessKey":"UserSecretKey-1","Token":"sess-token-1","Expiration":%q}`,
+			expiration.Format(time.RFC3339))
+	}))
+	defer server.Close()
+
+	os.Setenv("AWS_CONTAINER_CREDENTIALS_FULL_URI", server.URL+"/creds")
+	os.Setenv("AWS_CONTAINER_AUTHORIZATION_TOKEN", "token-1")
+	defer os.Unsetenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+	defer os.Unsetenv("AWS_CONTAINER_AUTHORIZATION_TOKEN")
+
+	target, err := NewAwsAuthenticatorFromECSCredentials("us-west-2")
+	assert.NoError(t, err)
+
+	credentials, err := target.CredentialProvider.Retrieve(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "UserID-1", credentials.AccessKeyId)
+	assert.Equal(t, "sess-token-1", credentials.SessionToken)
+	assert.True(t, credentials.Expiration.Before(expiration))
+}
+
+func TestNewAwsAuthenticatorFromECSCredentialsOutsideContainer(t *testing.T) {
+	_, err := NewAwsAuthenticatorFromECSCredentials("us-west-2")
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "no container credentials endpoint")
+}
+
+func TestNewAwsAuthenticatorWithCredentialOrder(t *testing.T) {
+	defer disableDefaultCredentialChain()()
+	dir, _ := ioutil.TempDir("", "sigv4")
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(dir+"/credentials", []byte("[default]\naws_access_key_id = UserID-2\naws_secret_access_key = UserSecretKey-2\n"), 0600)
+	os.Setenv("AWS_SHARED_CREDENTIALS_FILE", dir+"/credentials")
+	os.Setenv("AWS_ACCESS_KEY_ID", "UserID-1")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "UserSecretKey-1")
+	defer func() {
+		os.Unsetenv("AWS_ACCESS_KEY_ID")
+		os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+	}()
+
+	target, err := NewAwsAuthenticatorWithCredentialOrder("us-west-2",
+		[]CredentialSource{CredentialSourceSharedCredentials, CredentialSourceEnvironment})
+	assert.NoError(t, err)
+	credentials, _ := target.credentialProvider().Retrieve(context.Background())
+	assert.Equal(t, "UserID-2", credentials.AccessKeyId)
+
+	target, err = NewAwsAuthenticatorWithCredentialOrder("us-west-2",
+		[]CredentialSource{CredentialSourceEnvironment, CredentialSourceSharedCredentials})
+	assert.NoError(t, err)
+	credentials, _ = target.credentialProvider().Retrieve(context.Background())
+	assert.Equal(t, "UserID-1", credentials.AccessKeyId)
+}
+
+func TestNewAwsAuthenticatorWithCredentialOrderSkipsSourcesLeftOut(t *testing.T) {
+	defer disableDefaultCredentialChain()()
+	os.Setenv("AWS_ACCESS_KEY_ID", "UserID-1")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "UserSecretKey-1")
+	defer func() {
+		os.Unsetenv("AWS_ACCESS_KEY_ID")
+		os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+	}()
+
+	_, err := NewAwsAuthenticatorWithCredentialOrder("us-west-2",
+		[]CredentialSource{CredentialSourceContainer, CredentialSourceInstanceRole})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to retrieve AWS credentials")
+}
+
+func TestNewAwsAuthenticatorWithCredentialOrderRejectsUnknownSources(t *testing.T) {
+	_, err := NewAwsAuthenticatorWithCredentialOrder("us-west-2", nil)
+	assert.EqualError(t, err, "no credential sources given")
+
+	_, err = NewAwsAuthenticatorWithCredentialOrder("us-west-2", []CredentialSource{"vault"})
+	assert.EqualError(t, err, `unknown credential source "vault"`)
+}
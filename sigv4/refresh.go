@@ -0,0 +1,239 @@
+/*
+ *  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package sigv4
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// how often credentials are refreshed in the background when the given interval is not positive
+const defaultRefreshInterval = 5 * time.Minute
+
+// Option applied to the provider returned by NewBackgroundRefreshingCredentialProvider
+type RefreshOption func(refresher *backgroundRefresher)
+
+// waits a random extra amount of time, up to max, on top of the interval before each refresh.
+// instances started together then drift apart instead of all hitting the credential source
+// (e.g. STS) at the same moment on every interval.
+func WithRefreshIntervalJitter(max time.Duration) RefreshOption {
+	return func(refresher *backgroundRefresher) {
+		refresher.maxJitter = max
+	}
+}
+
+// bounds each background refresh instead of the default of 10 seconds, so a hung credential
+// source does not stall the refreshes that follow. Close also cancels a refresh in progress.
+func WithRefreshTimeout(timeout time.Duration) RefreshOption {
+	return func(refresher *backgroundRefresher) {
+		refresher.timeout = timeout
+	}
+}
+
+// receives the errors of background refreshes, which otherwise go unnoticed until the
+// previous credentials expire
+func WithRefreshLogger(logger Logger) RefreshOption {
+	return func(refresher *backgroundRefresher) {
+		refresher.logger = logger
+	}
+}
+
+// counts failed background refreshes as MetricBackgroundRefreshFailures
+func WithRefreshMetrics(metrics Metrics) RefreshOption {
+	return func(refresher *backgroundRefresher) {
+		refresher.metrics = metrics
+	}
+}
+
+type backgroundRefresher struct {
+	inner     CredentialProvider
+	interval  time.Duration
+	maxJitter time.Duration
+	timeout   time.Duration
+	logger    Logger
+	metrics   Metrics
+	randInt63 func(n int64) int64 // replaced in tests
+	now       func() time.Time    // replaced in tests
+
+	mu          sync.RWMutex
+	cached      bool
+	credentials SigV4Credentials
+	refreshing  *refreshCall // the in-flight refresh concurrent challenges wait for, if any
+
+	ctx    context.Context // done once the refresher is closed
+	cancel context.CancelFunc
+}
+
+// wraps a provider so credentials are retrieved in the background, once right away and then
+// every interval, plus the jitter of WithRefreshIntervalJitter. an interval that is not positive
+// falls back to 5 minutes and is reported to the logger set by WithRefreshLogger. challenges are
+// served the latest retrieved credentials without waiting on the provider, so its latency stays
+// out of connection establishment. only challenges arriving before the first retrieval succeeded
+// consult the provider, sharing a single retrieval.
+// a failed refresh keeps the previous credentials until their Expiration, after which challenges
+// retry the provider themselves and fail with ErrCredentialsExpired while it still fails, rather
+// than signing with keys the server rejects. the returned provider implements io.Closer,
+// closing it stops the background goroutine, and AwsAuthenticator.Close does so too.
+// a nil inner provider or callback returns nil without starting the goroutine.
+func NewBackgroundRefreshingCredentialProvider(inner CredentialProvider, interval time.Duration, opts ...RefreshOption) CredentialProvider {
+	if callback, ok := inner.(SigV4CredentialsCallback); inner == nil || ok && callback == nil {
+		return nil
+	}
+	refresher := &backgroundRefresher{
+		inner:     inner,
+		interval:  interval,
+		randInt63: rand.Int63n,
+		now:       time.Now}
+	refresher.ctx, refresher.cancel = context.WithCancel(context.Background())
+	for _, opt := range opts {
+		opt(refresher)
+	}
+	// a timer with a non-positive delay fires right away, which would call the provider in a loop
+	if refresher.interval <= 0 {
+		if refresher.logger != nil {
+			refresher.logger.Debugf("sigv4: background refresh interval %s is not positive, using %s",
+				refresher.interval, defaultRefreshInterval)
+		}
+		refresher.interval = defaultRefreshInterval
+	}
+	go refresher.run()
+	return refresher
+}
+
+// same as NewBackgroundRefreshingCredentialProvider for a callback. the returned func stops the
+// background goroutine and may be called more than once. a nil inner callback returns a nil
+// callback, which the constructors treat as no callback, and a stop func doing nothing.
+func NewBackgroundRefreshingCallback(inner SigV4CredentialsCallback, interval time.Duration, opts ...RefreshOption) (SigV4CredentialsCallback, func()) {
+	if inner == nil {
+		return nil, func() {}
+	}
+	refresher := NewBackgroundRefreshingCredentialProvider(inner, interval, opts...).(*backgroundRefresher)
+	callback := func() (SigV4Credentials, error) {
+		return refresher.Retrieve(context.Background())
+	}
+	return callback, func() { refresher.Close() }
+}
+
+func (r *backgroundRefresher) run() {
+	r.refreshInBackground()
+	timer := time.NewTimer(r.nextDelay())
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			r.refreshInBackground()
+			timer.Reset(r.nextDelay())
+		case <-r.ctx.Done():
+			return
+		}
+	}
+}
+
+// refreshes within the refresh timeout, reporting a failure to the logger and metrics
+func (r *backgroundRefresher) refreshInBackground() {
+	timeout := r.timeout
+	if timeout <= 0 {
+		timeout = defaultCredentialsTimeout
+	}
+	ctx, cancel := context.WithTimeout(r.ctx, timeout)
+	defer cancel()
+	if _, err := r.refresh(ctx, true); err != nil {
+		if r.logger != nil {
+			r.logger.Debugf("sigv4: background credential refresh failed: %v", err)
+		}
+		if r.metrics != nil {
+			r.metrics.Inc(MetricBackgroundRefreshFailures)
+		}
+	}
+}
+
+// the wait before the next refresh, drawn anew each time so instances keep drifting apart
+func (r *backgroundRefresher) nextDelay() time.Duration {
+	if r.maxJitter <= 0 {
+		return r.interval
+	}
+	return r.interval + time.Duration(r.randInt63(int64(r.maxJitter)))
+}
+
+// retrieves credentials from the inner provider and caches them. a refresh already in flight,
+// started by the background goroutine or another challenge, is waited for instead, as long as
+// ctx allows. unless force is set, unexpired credentials cached meanwhile are returned as is.
+func (r *backgroundRefresher) refresh(ctx context.Context, force bool) (SigV4Credentials, error) {
+	for {
+		r.mu.Lock()
+		if !force && r.cached && !r.credentials.expiresWithin(r.now(), 0) {
+			credentials := r.credentials
+			r.mu.Unlock()
+			return credentials, nil
+		}
+		if call := r.refreshing; call != nil {
+			r.mu.Unlock()
+			select {
+			case <-call.done:
+			case <-ctx.Done():
+				return SigV4Credentials{}, ctx.Err()
+			}
+			// the caller that started the refresh ran out of time, retry within this one's
+			if isContextError(call.err) && ctx.Err() == nil {
+				continue
+			}
+			return call.credentials, call.err
+		}
+		call := &refreshCall{done: make(chan struct{})}
+		r.refreshing = call
+		r.mu.Unlock()
+
+		call.credentials, call.err = r.inner.Retrieve(ctx)
+		r.mu.Lock()
+		r.refreshing = nil
+		if call.err == nil {
+			r.cached = true
+			r.credentials = call.credentials
+		}
+		r.mu.Unlock()
+		close(call.done)
+		return call.credentials, call.err
+	}
+}
+
+func (r *backgroundRefresher) Retrieve(ctx context.Context) (SigV4Credentials, error) {
+	r.mu.RLock()
+	cached, credentials := r.cached, r.credentials
+	r.mu.RUnlock()
+	if !cached {
+		return r.refresh(ctx, false)
+	}
+	if !credentials.expiresWithin(r.now(), 0) {
+		return credentials, nil
+	}
+
+	refreshed, err := r.refresh(ctx, false)
+	if err != nil {
+		return SigV4Credentials{}, fmt.Errorf("%w at %s and refreshing them failed: %v",
+			ErrCredentialsExpired, credentials.Expiration.UTC().Format(time.RFC3339), err)
+	}
+	return refreshed, nil
+}
+
+// stops the background refresh, safe to call more than once
+func (r *backgroundRefresher) Close() error {
+	r.cancel()
+	return nil
+}
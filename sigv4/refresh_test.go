@@ -0,0 +1,305 @@
+/*
+ *  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package sigv4
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// returns credentials numbered by call and optionally fails from a given call on
+type sequenceCallback struct {
+	mu     sync.Mutex
+	calls  int
+	failAt int
+}
+
+func (s *sequenceCallback) callback() (SigV4Credentials, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	if s.failAt > 0 && s.calls >= s.failAt {
+		return SigV4Credentials{}, errors.New("credential source unavailable")
+	}
+	return SigV4Credentials{
+		AccessKeyId:     fmt.Sprintf("UserID-%d", s.calls),
+		SecretAccessKey: "UserSecretKey-1",
+	}, nil
+}
+
+func (s *sequenceCallback) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls
+}
+
+func TestBackgroundRefreshingCallbackRefreshesOnInterval(t *testing.T) {
+	source := &sequenceCallback{}
+	callback, stop := NewBackgroundRefreshingCallback(source.callback, 5*time.Millisecond)
+	defer stop()
+
+	assert.Eventually(t, func() bool {
+		credentials, err := callback()
+		return err == nil && credentials.AccessKeyId == "UserID-3"
+	}, time.Second, time.Millisecond)
+}
+
+func TestBackgroundRefreshingCallbackRefreshesWithJitter(t *testing.T) {
+	source := &sequenceCallback{}
+	callback, stop := NewBackgroundRefreshingCallback(source.callback, 5*time.Millisecond,
+		WithRefreshIntervalJitter(5*time.Millisecond))
+	defer stop()
+
+	assert.Eventually(t, func() bool {
+		credentials, err := callback()
+		return err == nil && credentials.AccessKeyId == "UserID-3"
+	}, time.Second, time.Millisecond)
+}
+
+func TestBackgroundRefresherStopsServingExpiredCredentials(t *testing.T) {
+	now, _ := time.Parse(time.RFC3339, "2020-06-09T22:41:51Z")
+	failing := true
+	refresher := &backgroundRefresher{
+		inner: SigV4CredentialsCallback(func() (SigV4Credentials, error) {
+			if failing {
+				return SigV4Credentials{}, errors.New("credential source unavailable")
+			}
+			return SigV4Credentials{AccessKeyId: "UserID-2", SecretAccessKey: "UserSecretKey-2"}, nil
+		}),
+		now:         func() time.Time { return now },
+		cached:      true,
+		credentials: SigV4Credentials{AccessKeyId: "UserID-1", SecretAccessKey: "UserSecretKey-1", Expiration: now.Add(time.Minute)}}
+
+	credentials, err := refresher.Retrieve(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "UserID-1", credentials.AccessKeyId)
+
+	now = now.Add(time.Minute)
+	_, err = refresher.Retrieve(context.Background())
+	assert.True(t, errors.Is(err, ErrCredentialsExpired))
+	assert.EqualError(t, err, "AWS credentials have expired at 2020-06-09T22:42:51Z and refreshing them failed: credential source unavailable")
+
+	failing = false
+	credentials, err = refresher.Retrieve(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "UserID-2", credentials.AccessKeyId)
+}
+
+func TestBackgroundRefresherNextDelay(t *testing.T) {
+	refresher := &backgroundRefresher{interval: time.Hour}
+	assert.Equal(t, time.Hour, refresher.nextDelay())
+
+	WithRefreshIntervalJitter(10 * time.Minute)(refresher)
+	refresher.randInt63 = func(n int64) int64 {
+		assert.Equal(t, int64(10*time.Minute), n)
+		return int64(3 * time.Minute)
+	}
+	assert.Equal(t, time.Hour+3*time.Minute, refresher.nextDelay())
+}
+
+func TestBackgroundRefreshingCallbackServesCachedCredentials(t *testing.T) {
+	source := &sequenceCallback{}
+	callback, stop := NewBackgroundRefreshingCallback(source.callback, time.Hour)
+	defer stop()
+
+	assert.Eventually(t, func() bool { return source.count() == 1 }, time.Second, time.Millisecond)
+	for i := 0; i < 3; i++ {
+		credentials, err := callback()
+		assert.NoError(t, err)
+		assert.Equal(t, "UserID-1", credentials.AccessKeyId)
+	}
+	assert.Equal(t, 1, source.count())
+}
+
+func TestBackgroundRefreshingCallbackKeepsCredentialsOnFailure(t *testing.T) {
+	source := &sequenceCallback{failAt: 2}
+	callback, stop := NewBackgroundRefreshingCallback(source.callback, 5*time.Millisecond)
+	defer stop()
+
+	assert.Eventually(t, func() bool { return source.count() >= 3 }, time.Second, time.Millisecond)
+	credentials, err := callback()
+	assert.NoError(t, err)
+	assert.Equal(t, "UserID-1", credentials.AccessKeyId)
+}
+
+func TestBackgroundRefreshingCallbackFailsWithoutCredentials(t *testing.T) {
+	source := &sequenceCallback{failAt: 1}
+	callback, stop := NewBackgroundRefreshingCallback(source.callback, time.Hour)
+	defer stop()
+
+	_, err := callback()
+	assert.Error(t, err)
+}
+
+func TestBackgroundRefreshingCallbackStop(t *testing.T) {
+	source := &sequenceCallback{}
+	_, stop := NewBackgroundRefreshingCallback(source.callback, 5*time.Millisecond)
+
+	assert.Eventually(t, func() bool { return source.count() >= 2 }, time.Second, time.Millisecond)
+	stop()
+	stop()
+	// a tick already in progress may complete after stop returns
+	time.Sleep(10 * time.Millisecond)
+	calls := source.count()
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, calls, source.count())
+}
+
+func TestAwsAuthenticatorCloseStopsBackgroundRefresh(t *testing.T) {
+	source := &sequenceCallback{}
+	target := NewAwsAuthenticatorWithCredentialProvider("us-west-2",
+		NewBackgroundRefreshingCredentialProvider(SigV4CredentialsCallback(source.callback), 5*time.Millisecond))
+
+	assert.Eventually(t, func() bool { return source.count() >= 2 }, time.Second, time.Millisecond)
+	assert.NoError(t, target.Close())
+	assert.NoError(t, target.Close())
+	time.Sleep(10 * time.Millisecond)
+	calls := source.count()
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, calls, source.count())
+}
+
+func TestAwsAuthenticatorCloseWithoutBackgroundResources(t *testing.T) {
+	assert.NoError(t, AwsAuthenticator{Region: "us-west-2", AccessKeyId: "UserID-1", SecretAccessKey: "UserSecretKey-1"}.Close())
+	assert.NoError(t, NewAwsAuthenticatorWithCredentialCallback("us-west-2", func() (SigV4Credentials, error) {
+		return SigV4Credentials{}, nil
+	}).Close())
+}
+
+func TestBackgroundRefresherReportsFailures(t *testing.T) {
+	logger := &recordingLogger{}
+	metrics := &countingMetrics{}
+	refresher := &backgroundRefresher{
+		inner: SigV4CredentialsCallback(func() (SigV4Credentials, error) {
+			return SigV4Credentials{}, errors.New("credential source unavailable")
+		}),
+		logger:  logger,
+		metrics: metrics,
+		ctx:     context.Background()}
+
+	refresher.refreshInBackground()
+
+	assert.Equal(t, []string{"sigv4: background credential refresh failed: credential source unavailable"}, logger.lines)
+	assert.Equal(t, map[string]int{MetricBackgroundRefreshFailures: 1}, metrics.counters)
+}
+
+// blocks until its context is done, like a credential source that stopped responding
+type hungProvider struct{}
+
+func (hungProvider) Retrieve(ctx context.Context) (SigV4Credentials, error) {
+	<-ctx.Done()
+	return SigV4Credentials{}, ctx.Err()
+}
+
+func TestBackgroundRefresherTimesOutHungRefresh(t *testing.T) {
+	logger := &recordingLogger{}
+	refresher := &backgroundRefresher{inner: hungProvider{}, timeout: 5 * time.Millisecond, logger: logger, ctx: context.Background()}
+
+	refresher.refreshInBackground()
+
+	assert.Equal(t, []string{"sigv4: background credential refresh failed: context deadline exceeded"}, logger.lines)
+}
+
+func TestBackgroundRefresherCloseCancelsRefresh(t *testing.T) {
+	refresher := NewBackgroundRefreshingCredentialProvider(hungProvider{}, time.Hour, WithRefreshTimeout(time.Hour)).(*backgroundRefresher)
+	done := make(chan struct{})
+	go func() {
+		refresher.refreshInBackground()
+		close(done)
+	}()
+
+	refresher.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("refresh still running after Close")
+	}
+}
+
+// counts retrievals and blocks each one until released
+type gatedProvider struct {
+	mu      sync.Mutex
+	calls   int
+	release chan struct{}
+}
+
+func (p *gatedProvider) Retrieve(ctx context.Context) (SigV4Credentials, error) {
+	p.mu.Lock()
+	p.calls++
+	p.mu.Unlock()
+	<-p.release
+	return SigV4Credentials{AccessKeyId: "UserID-1", SecretAccessKey: "UserSecretKey-1"}, nil
+}
+
+func (p *gatedProvider) count() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.calls
+}
+
+func TestBackgroundRefresherSharesFirstRetrieval(t *testing.T) {
+	inner := &gatedProvider{release: make(chan struct{})}
+	refresher := &backgroundRefresher{inner: inner, now: time.Now}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			credentials, err := refresher.Retrieve(context.Background())
+			assert.NoError(t, err)
+			assert.Equal(t, "UserID-1", credentials.AccessKeyId)
+		}()
+	}
+	assert.Eventually(t, func() bool { return inner.count() == 1 }, time.Second, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	close(inner.release)
+	wg.Wait()
+
+	assert.Equal(t, 1, inner.count())
+}
+
+func TestBackgroundRefresherNonPositiveInterval(t *testing.T) {
+	for _, interval := range []time.Duration{0, -time.Minute} {
+		source := &sequenceCallback{}
+		logger := &recordingLogger{}
+		refresher := NewBackgroundRefreshingCredentialProvider(SigV4CredentialsCallback(source.callback), interval,
+			WithRefreshLogger(logger)).(*backgroundRefresher)
+
+		assert.Equal(t, defaultRefreshInterval, refresher.interval)
+		assert.Equal(t, []string{"sigv4: background refresh interval " + interval.String() + " is not positive, using 5m0s"}, logger.lines)
+		assert.Eventually(t, func() bool { return source.count() == 1 }, time.Second, time.Millisecond)
+		time.Sleep(20 * time.Millisecond)
+		assert.Equal(t, 1, source.count())
+		refresher.Close()
+	}
+}
+
+func TestBackgroundRefreshingNilInner(t *testing.T) {
+	callback, stop := NewBackgroundRefreshingCallback(nil, time.Hour)
+	assert.Nil(t, callback)
+	assert.NotPanics(t, stop)
+
+	assert.Nil(t, NewBackgroundRefreshingCredentialProvider(nil, time.Hour))
+	assert.Nil(t, NewBackgroundRefreshingCredentialProvider(SigV4CredentialsCallback(nil), time.Hour))
+}
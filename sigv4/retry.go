@@ -0,0 +1,77 @@
+/*
+ *  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package sigv4
+
+import (
+	"context"
+	"time"
+)
+
+type retryingProvider struct {
+	inner    CredentialProvider
+	attempts int
+	backoff  time.Duration
+	sleep    func(ctx context.Context, d time.Duration) error // replaced in tests
+}
+
+// wraps a provider so failed retrievals are retried, up to attempts in total, waiting backoff
+// before the first retry and doubling the wait before each further one. the last error is
+// returned once all attempts fail. waiting stops early when the context is done, so the
+// authenticator's CredentialsTimeout also bounds the retries.
+func NewRetryingCredentialProvider(inner CredentialProvider, attempts int, backoff time.Duration) CredentialProvider {
+	return &retryingProvider{
+		inner:    inner,
+		attempts: attempts,
+		backoff:  backoff,
+		sleep:    sleepContext}
+}
+
+func (p *retryingProvider) Retrieve(ctx context.Context) (SigV4Credentials, error) {
+	wait := p.backoff
+	for attempt := 1; ; attempt++ {
+		credentials, err := p.inner.Retrieve(ctx)
+		if err == nil || attempt >= p.attempts {
+			return credentials, err
+		}
+		if sleepErr := p.sleep(ctx, wait); sleepErr != nil {
+			return SigV4Credentials{}, err
+		}
+		wait *= 2
+	}
+}
+
+// waits for d, or returns the context's error if it is done first
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// wraps a callback so it is retried with exponential backoff, see NewRetryingCredentialProvider.
+// makes connection establishment resilient to brief failures of the credential source, such as
+// STS throttling.
+func NewRetryingCredentialsCallback(inner SigV4CredentialsCallback, attempts int, backoff time.Duration) SigV4CredentialsCallback {
+	provider := NewRetryingCredentialProvider(inner, attempts, backoff)
+	return func() (SigV4Credentials, error) {
+		return provider.Retrieve(context.Background())
+	}
+}
@@ -0,0 +1,98 @@
+/*
+ *  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package sigv4
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fails the first failures retrievals
+type flakyProvider struct {
+	failures int
+	calls    int
+}
+
+func (p *flakyProvider) Retrieve(ctx context.Context) (SigV4Credentials, error) {
+	p.calls++
+	if p.calls <= p.failures {
+		return SigV4Credentials{}, errors.New("throttled")
+	}
+	return SigV4Credentials{AccessKeyId: "UserID-1", SecretAccessKey: "UserSecretKey-1"}, nil
+}
+
+func buildRetryingTarget(inner CredentialProvider, attempts int) (*retryingProvider, *[]time.Duration) {
+	var waits []time.Duration
+	provider := NewRetryingCredentialProvider(inner, attempts, 100*time.Millisecond).(*retryingProvider)
+	provider.sleep = func(ctx context.Context, d time.Duration) error {
+		waits = append(waits, d)
+		return ctx.Err()
+	}
+	return provider, &waits
+}
+
+func TestRetryingProviderRecovers(t *testing.T) {
+	inner := &flakyProvider{failures: 2}
+	provider, waits := buildRetryingTarget(inner, 3)
+
+	credentials, err := provider.Retrieve(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, "UserID-1", credentials.AccessKeyId)
+	assert.Equal(t, 3, inner.calls)
+	assert.Equal(t, []time.Duration{100 * time.Millisecond, 200 * time.Millisecond}, *waits)
+}
+
+func TestRetryingProviderGivesUp(t *testing.T) {
+	inner := &flakyProvider{failures: 5}
+	provider, waits := buildRetryingTarget(inner, 3)
+
+	_, err := provider.Retrieve(context.Background())
+
+	assert.EqualError(t, err, "throttled")
+	assert.Equal(t, 3, inner.calls)
+	assert.Len(t, *waits, 2)
+}
+
+func TestRetryingProviderStopsWhenContextIsDone(t *testing.T) {
+	inner := &flakyProvider{failures: 5}
+	provider, _ := buildRetryingTarget(inner, 3)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := provider.Retrieve(ctx)
+
+	assert.EqualError(t, err, "throttled")
+	assert.Equal(t, 1, inner.calls)
+}
+
+func TestRetryingCredentialsCallback(t *testing.T) {
+	inner := &flakyProvider{failures: 1}
+	callback := NewRetryingCredentialsCallback(func() (SigV4Credentials, error) {
+		return inner.Retrieve(context.Background())
+	}, 2, time.Millisecond)
+
+	credentials, err := callback()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "UserID-1", credentials.AccessKeyId)
+	assert.Equal(t, 2, inner.calls)
+}
@@ -0,0 +1,97 @@
+/*
+ *  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package sigv4
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+)
+
+// how often NewAwsAuthenticatorFromSecretsManager re-fetches the secret when no interval is given
+const defaultSecretRefreshInterval = time.Hour
+
+// reads credentials from a Secrets Manager secret holding a JSON object such as
+// {"accessKeyId": "...", "secretAccessKey": "...", "sessionToken": "..."}.
+// field names are matched case-insensitively and the session token is optional.
+type secretsManagerProvider struct {
+	client   secretsmanageriface.SecretsManagerAPI
+	secretId string
+}
+
+func (p secretsManagerProvider) Retrieve(ctx context.Context) (SigV4Credentials, error) {
+	output, err := p.client.GetSecretValueWithContext(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(p.secretId)})
+	if err != nil {
+		return SigV4Credentials{}, fmt.Errorf("failed to get secret %s: %w", p.secretId, err)
+	}
+
+	var secret struct {
+		AccessKeyId     string
+		SecretAccessKey string
+		SessionToken    string
+	}
+	// the secret value is never included in the error
+	if err := json.Unmarshal([]byte(aws.StringValue(output.SecretString)), &secret); err != nil {
+		return SigV4Credentials{}, fmt.Errorf("secret %s is not a JSON object with accessKeyId and secretAccessKey", p.secretId)
+	}
+	// values pasted into the secret commonly carry a trailing newline, trimmed as by the other loaders
+	return SigV4Credentials{
+		AccessKeyId:     strings.TrimSpace(secret.AccessKeyId),
+		SecretAccessKey: strings.TrimSpace(secret.SecretAccessKey),
+		SessionToken:    strings.TrimSpace(secret.SessionToken)}, nil
+}
+
+// initializes authenticator with credentials stored in the Secrets Manager secret secretId, a JSON
+// object with accessKeyId, secretAccessKey and an optional sessionToken. the secret is read
+// with the default credential provider chain in region, then re-fetched once refreshInterval has
+// passed, so rotated credentials are picked up. a zero refreshInterval re-fetches hourly.
+// the secret is fetched once here, so a missing or malformed secret is reported right away.
+func NewAwsAuthenticatorFromSecretsManager(region string, secretId string, refreshInterval time.Duration, opts ...Option) (AwsAuthenticator, error) {
+	if err := validateRegion(region); err != nil {
+		return AwsAuthenticator{}, err
+	}
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		return AwsAuthenticator{}, fmt.Errorf("failed to create AWS session: %w", err)
+	}
+	return newAwsAuthenticatorFromSecretsManager(region, secretsmanager.New(sess), secretId, refreshInterval, opts)
+}
+
+func newAwsAuthenticatorFromSecretsManager(region string, client secretsmanageriface.SecretsManagerAPI, secretId string, refreshInterval time.Duration, opts []Option) (AwsAuthenticator, error) {
+	if refreshInterval <= 0 {
+		refreshInterval = defaultSecretRefreshInterval
+	}
+	provider := NewCachingCredentialProvider(secretsManagerProvider{client, secretId},
+		WithCacheTTL(refreshInterval), WithLastKnownGoodOnError())
+
+	credentials, err := provider.Retrieve(context.Background())
+	if err != nil {
+		return AwsAuthenticator{}, fmt.Errorf("failed to retrieve AWS credentials: %w", err)
+	}
+	if err := validateCredentials(credentials, time.Now()); err != nil {
+		return AwsAuthenticator{}, fmt.Errorf("secret %s: %w", secretId, err)
+	}
+	return NewAwsAuthenticatorWithCredentialProvider(region, provider, opts...), nil
+}
@@ -0,0 +1,95 @@
+/*
+ *  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package sigv4
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	"github.com/stretchr/testify/assert"
+)
+
+// serves secrets as stored in values and counts the requests
+type fakeSecretsManager struct {
+	secretsmanageriface.SecretsManagerAPI
+	values map[string]string
+	calls  int
+}
+
+func (m *fakeSecretsManager) GetSecretValueWithContext(ctx aws.Context, input *secretsmanager.GetSecretValueInput, opts ...request.Option) (*secretsmanager.GetSecretValueOutput, error) {
+	m.calls++
+	value, ok := m.values[aws.StringValue(input.SecretId)]
+	if !ok {
+		return nil, errors.New("ResourceNotFoundException")
+	}
+	return &secretsmanager.GetSecretValueOutput{SecretString: aws.String(value)}, nil
+}
+
+func TestNewAwsAuthenticatorFromSecretsManager(t *testing.T) {
+	client := &fakeSecretsManager{values: map[string]string{
+		"keyspaces": `{"accessKeyId":"UserID-1","secretAccessKey":"UserSecretKey-1","sessionToken":"sess-token-1"}`}}
+
+	target, err := newAwsAuthenticatorFromSecretsManager("us-west-2", client, "keyspaces", 0, []Option{WithClock(stdClock)})
+	assert.NoError(t, err)
+
+	_, challenger, _ := target.Challenge(nil)
+	resp, _, err := challenger.Challenge(stdNonce)
+	assert.NoError(t, err)
+	expected := "signature=7f3691c18a81b8ce7457699effbfae5b09b4e0714ab38c1292dbdf082c9ddd87,access_key=UserID-1,amzdate=2020-06-09T22:41:51.000Z,session_token=sess-token-1"
+	assert.Equal(t, expected, string(resp))
+	// served from the cache until the refresh interval passes
+	assert.Equal(t, 1, client.calls)
+}
+
+func TestNewAwsAuthenticatorFromSecretsManagerTrimsValues(t *testing.T) {
+	client := &fakeSecretsManager{values: map[string]string{
+		"keyspaces": `{"accessKeyId":" UserID-1\n","secretAccessKey":"UserSecretKey-1\n","sessionToken":"sess-token-1\r\n"}`}}
+
+	target, err := newAwsAuthenticatorFromSecretsManager("us-west-2", client, "keyspaces", 0, []Option{WithClock(stdClock)})
+	assert.NoError(t, err)
+
+	_, challenger, _ := target.Challenge(nil)
+	resp, _, err := challenger.Challenge(stdNonce)
+	assert.NoError(t, err)
+	expected := "signature=7f3691c18a81b8ce7457699effbfae5b09b4e0714ab38c1292dbdf082c9ddd87,access_key=UserID-1,amzdate=2020-06-09T22:41:51.000Z,session_token=sess-token-1"
+	assert.Equal(t, expected, string(resp))
+}
+
+func TestNewAwsAuthenticatorFromSecretsManagerFailures(t *testing.T) {
+	client := &fakeSecretsManager{values: map[string]string{
+		"plain":   "UserSecretKey-1",
+		"partial": `{"AccessKeyId":"UserID-1"}`}}
+
+	_, err := newAwsAuthenticatorFromSecretsManager("us-west-2", client, "missing", 0, nil)
+	assert.EqualError(t, err, "failed to retrieve AWS credentials: failed to get secret missing: ResourceNotFoundException")
+
+	_, err = newAwsAuthenticatorFromSecretsManager("us-west-2", client, "plain", 0, nil)
+	assert.EqualError(t, err, "failed to retrieve AWS credentials: secret plain is not a JSON object with accessKeyId and secretAccessKey")
+	assert.NotContains(t, err.Error(), "UserSecretKey-1")
+
+	_, err = newAwsAuthenticatorFromSecretsManager("us-west-2", client, "partial", 0, nil)
+	assert.EqualError(t, err, "secret partial: AWS secret access key is empty")
+}
+
+func TestNewAwsAuthenticatorFromSecretsManagerRejectsInvalidRegion(t *testing.T) {
+	_, err := NewAwsAuthenticatorFromSecretsManager("", "keyspaces", 0)
+	assert.True(t, errors.Is(err, ErrRegionNotConfigured))
+}
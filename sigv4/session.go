@@ -0,0 +1,278 @@
+/*
+ *  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package sigv4
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/gocql/gocql"
+)
+
+// port Amazon Keyspaces listens on for TLS connections
+const keyspacesPort = 9142
+
+// Option applied to the cluster configuration assembled by OpenKeyspacesSession.
+// options are applied in order after the defaults, so they can override anything.
+type SessionOption func(cluster *gocql.ClusterConfig)
+
+// sets the keyspace the session is bound to
+func WithKeyspace(keyspace string) SessionOption {
+	return func(cluster *gocql.ClusterConfig) {
+		cluster.Keyspace = keyspace
+	}
+}
+
+// overrides the default LOCAL_QUORUM consistency
+func WithConsistency(consistency gocql.Consistency) SessionOption {
+	return func(cluster *gocql.ClusterConfig) {
+		cluster.Consistency = consistency
+	}
+}
+
+// trusts the certificates in the given PEM file instead of the system roots,
+// e.g. the Starfield certificate referenced in the README.
+func WithCaPath(caPath string) SessionOption {
+	return func(cluster *gocql.ClusterConfig) {
+		cluster.SslOpts.CaPath = caPath
+	}
+}
+
+// returns the regional Amazon Keyspaces endpoint, e.g. cassandra.us-west-2.amazonaws.com.
+// the domain follows the region's partition, so China regions use amazonaws.com.cn.
+func keyspacesEndpoint(region string) string {
+	dnsSuffix := "amazonaws.com"
+	if partition, ok := endpoints.PartitionForRegion(endpoints.DefaultPartitions(), region); ok {
+		dnsSuffix = partition.DNSSuffix()
+	}
+	return fmt.Sprintf("cassandra.%s.%s", region, dnsSuffix)
+}
+
+// service prefixes of Amazon Keyspaces endpoints
+var keyspacesHostPrefixes = []string{"cassandra-fips.", "cassandra."}
+
+// domain suffixes of every partition, plus the dual-stack (IPv4 and IPv6) api.aws names and
+// the names of VPC interface endpoints
+func keyspacesHostSuffixes() []string {
+	suffixes := []string{".api.aws"}
+	for _, partition := range endpoints.DefaultPartitions() {
+		suffixes = append(suffixes, "."+partition.DNSSuffix(), ".vpce."+partition.DNSSuffix())
+	}
+	return suffixes
+}
+
+// extracts the region from an Amazon Keyspaces endpoint such as
+// cassandra.us-east-1.amazonaws.com, the dual-stack cassandra.us-east-1.api.aws or the DNS name
+// of a VPC interface endpoint such as
+// vpce-0123456789abcdef0-abcdefgh.cassandra.us-east-1.vpce.amazonaws.com. a trailing port is
+// ignored. IP literals, including bracketed IPv6 addresses, do not identify a region and return
+// an error.
+func RegionFromKeyspacesHost(host string) (string, error) {
+	name := host
+	if h, _, err := net.SplitHostPort(name); err == nil {
+		name = h
+	}
+	name = strings.TrimSuffix(strings.TrimPrefix(name, "["), "]")
+	if net.ParseIP(name) != nil {
+		return "", fmt.Errorf("host %q is an IP address and does not identify a region", host)
+	}
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	// VPC endpoint names prefix the service name with the endpoint id
+	if strings.HasPrefix(name, "vpce-") {
+		if i := strings.Index(name, "."); i >= 0 {
+			name = name[i+1:]
+		}
+	}
+
+	for _, prefix := range keyspacesHostPrefixes {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		for _, suffix := range keyspacesHostSuffixes() {
+			if !strings.HasSuffix(name, suffix) {
+				continue
+			}
+			region := strings.TrimSuffix(strings.TrimPrefix(name, prefix), suffix)
+			if region != "" && !strings.Contains(region, ".") {
+				return region, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("host %q is not an Amazon Keyspaces endpoint", host)
+}
+
+// returns a gocql AuthProvider picking the authenticator for each host from authenticators,
+// keyed by region. the host's data center is tried first, Amazon Keyspaces names it after the
+// region, then the region in the host name, which is all that is known about contact points
+// before the first connection. hosts matching no region fail to connect with an error.
+// set it as ClusterConfig.AuthProvider, leaving ClusterConfig.Authenticator unset.
+func NewRegionalAuthProvider(authenticators map[string]AwsAuthenticator) func(host *gocql.HostInfo) (gocql.Authenticator, error) {
+	return func(host *gocql.HostInfo) (gocql.Authenticator, error) {
+		hostname, _, _ := net.SplitHostPort(host.HostnameAndPort())
+		return regionalAuthenticator(authenticators, host.DataCenter(), hostname)
+	}
+}
+
+func regionalAuthenticator(authenticators map[string]AwsAuthenticator, dataCenter string, hostname string) (gocql.Authenticator, error) {
+	if auth, ok := authenticators[dataCenter]; ok {
+		return auth, nil
+	}
+	region, err := RegionFromKeyspacesHost(hostname)
+	if err != nil {
+		return nil, fmt.Errorf("no authenticator for data center %q: %w", dataCenter, err)
+	}
+	if auth, ok := authenticators[region]; ok {
+		return auth, nil
+	}
+	return nil, fmt.Errorf("no authenticator for region %s of host %q", region, hostname)
+}
+
+// the host name of a contact point, without its port
+func hostName(contactPoint string) string {
+	if host, _, err := net.SplitHostPort(contactPoint); err == nil {
+		return host
+	}
+	return contactPoint
+}
+
+// assembles the cluster configuration used by OpenKeyspacesSession.
+// an empty contact point defaults to the regional endpoint.
+func newKeyspacesClusterConfig(region string, contactPoint string, auth AwsAuthenticator, opts ...SessionOption) *gocql.ClusterConfig {
+	if contactPoint == "" {
+		contactPoint = keyspacesEndpoint(region)
+	}
+
+	serverName := hostName(contactPoint)
+
+	if auth.Region == "" {
+		auth.Region = region
+	}
+
+	cluster := gocql.NewCluster(contactPoint)
+	cluster.Port = keyspacesPort
+	cluster.Authenticator = auth
+	cluster.Consistency = gocql.LocalQuorum
+	// Amazon Keyspaces is reached through a single endpoint, so peer discovery is not useful
+	cluster.DisableInitialHostLookup = true
+	cluster.SslOpts = &gocql.SslOptions{
+		Config: &tls.Config{
+			ServerName: serverName,
+			MinVersion: tls.VersionTLS12,
+		},
+		EnableHostVerification: true,
+	}
+
+	for _, opt := range opts {
+		opt(cluster)
+	}
+
+	return cluster
+}
+
+// opens a gocql session against Amazon Keyspaces with TLS, LOCAL_QUORUM consistency,
+// port 9142 and the given authenticator attached. the authenticator region defaults to
+// the region argument when not set, and options are applied on top of these defaults.
+// when the server rejected the handshake, the error wraps ErrAccessDenied and leads with the
+// server's reason, see AuthenticationFailure.
+func OpenKeyspacesSession(region string, contactPoint string, auth AwsAuthenticator, opts ...SessionOption) (*gocql.Session, error) {
+	cluster := newKeyspacesClusterConfig(region, contactPoint, auth, opts...)
+	recorder := &rejectionRecorder{next: cluster.ConnectObserver}
+	cluster.ConnectObserver = recorder
+	session, err := cluster.CreateSession()
+	if err != nil {
+		if rejection := recorder.lastRejection(); rejection != nil {
+			return nil, fmt.Errorf("%w (%v)", rejection, err)
+		}
+		return nil, err
+	}
+	return session, nil
+}
+
+// returned wrapped by AuthenticationFailure and OpenKeyspacesSession when the server rejected
+// the signed response, e.g. for unknown credentials or a missing IAM permission
+var ErrAccessDenied = errors.New("access denied")
+
+// CQL native protocol code of the error a rejected handshake is answered with
+const errCodeAuthentication = 0x0100
+
+// gocql returns the server's rejection of a handshake without passing it to the authenticator,
+// and CreateSession only keeps its text. this translates the error of a connection whose
+// handshake was rejected, e.g. ObservedConnect.Err, into an error wrapping ErrAccessDenied
+// with the server's reason. other errors, including nil, are returned unchanged.
+func AuthenticationFailure(err error) error {
+	var requestErr gocql.RequestError
+	if errors.As(err, &requestErr) && requestErr.Code() == errCodeAuthentication {
+		return fmt.Errorf("%w: %s", ErrAccessDenied, requestErr.Message())
+	}
+	return err
+}
+
+// remembers the last rejected handshake among the connections it observes, passing every
+// observation on to the observer configured on the cluster, if any
+type rejectionRecorder struct {
+	next gocql.ConnectObserver
+
+	mu        sync.Mutex
+	rejection error
+}
+
+func (r *rejectionRecorder) ObserveConnect(observed gocql.ObservedConnect) {
+	if err := AuthenticationFailure(observed.Err); errors.Is(err, ErrAccessDenied) {
+		r.mu.Lock()
+		r.rejection = err
+		r.mu.Unlock()
+	}
+	if r.next != nil {
+		r.next.ObserveConnect(observed)
+	}
+}
+
+func (r *rejectionRecorder) lastRejection() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rejection
+}
+
+// returns a cluster configuration for Amazon Keyspaces with the same defaults as
+// OpenKeyspacesSession, signed with credentials from the AWS SDK's default credential provider
+// chain, for callers that adjust it further before creating the session. hosts default to the
+// regional endpoint. gocql verifies every host against the single TLS server name of the
+// configuration, so all hosts must share a host name, e.g. the same endpoint on several ports.
+// fails when they differ, the region is invalid or no credentials can be loaded.
+func NewKeyspacesCluster(region string, hosts ...string) (*gocql.ClusterConfig, error) {
+	for _, host := range hosts {
+		if hostName(host) != hostName(hosts[0]) {
+			return nil, fmt.Errorf("hosts %s and %s differ in the TLS server name they are verified against, "+
+				"create a cluster per endpoint", hosts[0], host)
+		}
+	}
+	auth, err := NewAwsAuthenticatorWithRegionE(region)
+	if err != nil {
+		return nil, err
+	}
+	if len(hosts) == 0 {
+		return newKeyspacesClusterConfig(region, "", auth), nil
+	}
+	cluster := newKeyspacesClusterConfig(region, hosts[0], auth)
+	cluster.Hosts = hosts
+	return cluster, nil
+}
@@ -0,0 +1,231 @@
+/*
+ *  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package sigv4
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/gocql/gocql"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingConnectObserver struct {
+	observed []gocql.ObservedConnect
+}
+
+func (o *recordingConnectObserver) ObserveConnect(observed gocql.ObservedConnect) {
+	o.observed = append(o.observed, observed)
+}
+
+func TestKeyspacesClusterConfigDefaults(t *testing.T) {
+	cluster := newKeyspacesClusterConfig("us-west-2", "", AwsAuthenticator{})
+
+	assert.Equal(t, []string{"cassandra.us-west-2.amazonaws.com"}, cluster.Hosts)
+	assert.Equal(t, 9142, cluster.Port)
+	assert.Equal(t, gocql.LocalQuorum, cluster.Consistency)
+	assert.True(t, cluster.DisableInitialHostLookup)
+	assert.True(t, cluster.SslOpts.EnableHostVerification)
+	assert.Equal(t, "cassandra.us-west-2.amazonaws.com", cluster.SslOpts.Config.ServerName)
+
+	auth := cluster.Authenticator.(AwsAuthenticator)
+	assert.Equal(t, "us-west-2", auth.Region)
+}
+
+func TestKeyspacesEndpointFollowsPartition(t *testing.T) {
+	assert.Equal(t, "cassandra.us-east-1.amazonaws.com", keyspacesEndpoint("us-east-1"))
+	assert.Equal(t, "cassandra.us-gov-west-1.amazonaws.com", keyspacesEndpoint("us-gov-west-1"))
+	assert.Equal(t, "cassandra.cn-north-1.amazonaws.com.cn", keyspacesEndpoint("cn-north-1"))
+	assert.Equal(t, "cassandra.us-iso-east-1.c2s.ic.gov", keyspacesEndpoint("us-iso-east-1"))
+}
+
+func TestKeyspacesClusterConfigContactPointWithPort(t *testing.T) {
+	cluster := newKeyspacesClusterConfig("us-east-2", "cassandra.us-east-2.amazonaws.com:9142", AwsAuthenticator{Region: "us-east-1"})
+
+	assert.Equal(t, []string{"cassandra.us-east-2.amazonaws.com:9142"}, cluster.Hosts)
+	assert.Equal(t, "cassandra.us-east-2.amazonaws.com", cluster.SslOpts.Config.ServerName)
+
+	// an explicitly configured authenticator region is left alone
+	auth := cluster.Authenticator.(AwsAuthenticator)
+	assert.Equal(t, "us-east-1", auth.Region)
+}
+
+func TestKeyspacesClusterConfigOptions(t *testing.T) {
+	cluster := newKeyspacesClusterConfig("us-west-2", "", AwsAuthenticator{},
+		WithKeyspace("ks"),
+		WithConsistency(gocql.One),
+		WithCaPath("/tmp/sf-class2-root.crt"),
+		func(cluster *gocql.ClusterConfig) { cluster.NumConns = 5 })
+
+	assert.Equal(t, "ks", cluster.Keyspace)
+	assert.Equal(t, gocql.One, cluster.Consistency)
+	assert.Equal(t, "/tmp/sf-class2-root.crt", cluster.SslOpts.CaPath)
+	assert.Equal(t, 5, cluster.NumConns)
+}
+
+func TestRegionFromKeyspacesHost(t *testing.T) {
+	hosts := map[string]string{
+		"cassandra.us-east-1.amazonaws.com":          "us-east-1",
+		"cassandra.us-east-1.amazonaws.com:9142":     "us-east-1",
+		"Cassandra.EU-West-1.amazonaws.com.":         "eu-west-1",
+		"cassandra.us-east-1.api.aws":                "us-east-1",
+		"cassandra.us-east-1.api.aws:9142":           "us-east-1",
+		"cassandra-fips.us-gov-west-1.amazonaws.com": "us-gov-west-1",
+		"cassandra.cn-north-1.amazonaws.com.cn":      "cn-north-1",
+		"cassandra.us-iso-east-1.c2s.ic.gov":         "us-iso-east-1",
+	}
+	for host, expected := range hosts {
+		region, err := RegionFromKeyspacesHost(host)
+		assert.NoError(t, err, host)
+		assert.Equal(t, expected, region, host)
+	}
+}
+
+func TestRegionFromVPCEndpointHost(t *testing.T) {
+	for _, host := range []string{
+		"vpce-0123456789abcdef0-abcdefgh.cassandra.us-east-1.vpce.amazonaws.com",
+		"vpce-0123456789abcdef0-abcdefgh-us-east-1a.cassandra.us-east-1.vpce.amazonaws.com:9142",
+	} {
+		region, err := RegionFromKeyspacesHost(host)
+		assert.NoError(t, err, host)
+		assert.Equal(t, "us-east-1", region, host)
+	}
+}
+
+func TestRegionFromKeyspacesHostRejectsOtherHosts(t *testing.T) {
+	hosts := []string{
+		"[2600:1f18::1]:9142",
+		"2600:1f18::1",
+		"[2600:1f18::1]",
+		"10.0.0.1:9142",
+		"localhost:9042",
+		"cassandra.amazonaws.com",
+		"cassandra.a.b.amazonaws.com",
+		"dynamodb.us-east-1.amazonaws.com",
+		"vpce-0123456789abcdef0-abcdefgh.dynamodb.us-east-1.vpce.amazonaws.com",
+		"keyspaces.internal.example.com",
+	}
+	for _, host := range hosts {
+		_, err := RegionFromKeyspacesHost(host)
+		assert.Error(t, err, host)
+	}
+}
+
+func TestNewKeyspacesCluster(t *testing.T) {
+	os.Setenv("AWS_ACCESS_KEY_ID", "UserID-1")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "UserSecretKey-1")
+	defer func() {
+		os.Unsetenv("AWS_ACCESS_KEY_ID")
+		os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+	}()
+
+	cluster, err := NewKeyspacesCluster("us-west-2")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"cassandra.us-west-2.amazonaws.com"}, cluster.Hosts)
+	assert.Equal(t, 9142, cluster.Port)
+	assert.Equal(t, gocql.LocalQuorum, cluster.Consistency)
+	assert.True(t, cluster.SslOpts.EnableHostVerification)
+	auth := cluster.Authenticator.(AwsAuthenticator)
+	assert.Equal(t, "us-west-2", auth.Region)
+	assert.Equal(t, "UserID-1", auth.AccessKeyId)
+
+	cluster, err = NewKeyspacesCluster("us-east-1", "cassandra.us-east-1.amazonaws.com:9142", "cassandra.us-east-1.amazonaws.com")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"cassandra.us-east-1.amazonaws.com:9142", "cassandra.us-east-1.amazonaws.com"}, cluster.Hosts)
+	assert.Equal(t, "cassandra.us-east-1.amazonaws.com", cluster.SslOpts.Config.ServerName)
+
+	// the api.aws host would be verified against the amazonaws.com name and fail
+	_, err = NewKeyspacesCluster("us-east-1", "cassandra.us-east-1.amazonaws.com:9142", "cassandra.us-east-1.api.aws:9142")
+	assert.EqualError(t, err, "hosts cassandra.us-east-1.amazonaws.com:9142 and cassandra.us-east-1.api.aws:9142 "+
+		"differ in the TLS server name they are verified against, create a cluster per endpoint")
+}
+
+func TestNewKeyspacesClusterRejectsInvalidRegion(t *testing.T) {
+	_, err := NewKeyspacesCluster("not a region")
+	assert.Error(t, err)
+}
+
+func TestRegionalAuthenticator(t *testing.T) {
+	authenticators := map[string]AwsAuthenticator{
+		"us-east-1": {Region: "us-east-1"},
+		"eu-west-1": {Region: "eu-west-1"},
+	}
+
+	auth, err := regionalAuthenticator(authenticators, "eu-west-1", "10.0.0.1")
+	assert.NoError(t, err)
+	assert.Equal(t, "eu-west-1", auth.(AwsAuthenticator).Region)
+
+	auth, err = regionalAuthenticator(authenticators, "", "cassandra.us-east-1.amazonaws.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "us-east-1", auth.(AwsAuthenticator).Region)
+
+	_, err = regionalAuthenticator(authenticators, "", "cassandra.ap-south-1.amazonaws.com")
+	assert.EqualError(t, err, `no authenticator for region ap-south-1 of host "cassandra.ap-south-1.amazonaws.com"`)
+
+	_, err = regionalAuthenticator(authenticators, "dc1", "10.0.0.1")
+	assert.EqualError(t, err, `no authenticator for data center "dc1": host "10.0.0.1" is an IP address and does not identify a region`)
+}
+
+func TestNewRegionalAuthProvider(t *testing.T) {
+	provider := NewRegionalAuthProvider(map[string]AwsAuthenticator{"us-east-1": {Region: "us-east-1"}})
+
+	host := &gocql.HostInfo{}
+	host.SetConnectAddress(net.ParseIP("10.0.0.1"))
+	_, err := provider(host)
+	assert.Error(t, err)
+}
+
+// mimics the error gocql returns for an ERROR frame
+type fakeRequestError struct {
+	code    int
+	message string
+}
+
+func (e fakeRequestError) Code() int       { return e.code }
+func (e fakeRequestError) Message() string { return e.message }
+func (e fakeRequestError) Error() string   { return e.message }
+
+func TestAuthenticationFailure(t *testing.T) {
+	rejected := fakeRequestError{0x0100, "Provided username UserID-1 and/or password are incorrect"}
+
+	err := AuthenticationFailure(rejected)
+	assert.True(t, errors.Is(err, ErrAccessDenied))
+	assert.EqualError(t, err, "access denied: Provided username UserID-1 and/or password are incorrect")
+
+	err = AuthenticationFailure(fmt.Errorf("connection failed: %w", rejected))
+	assert.True(t, errors.Is(err, ErrAccessDenied))
+
+	other := fakeRequestError{0x1000, "Cannot achieve consistency level"}
+	assert.Equal(t, other, AuthenticationFailure(other))
+	assert.Nil(t, AuthenticationFailure(nil))
+}
+
+func TestRejectionRecorder(t *testing.T) {
+	next := &recordingConnectObserver{}
+	recorder := &rejectionRecorder{next: next}
+
+	recorder.ObserveConnect(gocql.ObservedConnect{Err: errors.New("dial tcp: connection refused")})
+	assert.Nil(t, recorder.lastRejection())
+
+	recorder.ObserveConnect(gocql.ObservedConnect{Err: fakeRequestError{0x0100, "Authentication failure: expired token"}})
+	recorder.ObserveConnect(gocql.ObservedConnect{})
+	assert.EqualError(t, recorder.lastRejection(), "access denied: Authentication failure: expired token")
+	assert.Len(t, next.observed, 3)
+}
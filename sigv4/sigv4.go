@@ -18,142 +18,981 @@
 package sigv4
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"regexp"
+	"strings"
 	"time"
+	"unicode"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
 	"github.com/aws/aws-sigv4-auth-cassandra-gocql-driver-plugin/sigv4/internal"
 	"github.com/gocql/gocql"
 )
 
+// Credentials to sign with, a plain value holding no references: callbacks and providers return
+// it by value and each challenge signs with its own copy, so credentials handed to concurrent
+// challenges never share state. keep it that way when adding fields, e.g. no slices or maps.
 type SigV4Credentials struct {
 	AccessKeyId     string
 	SecretAccessKey string
 	SessionToken    string
+	Expiration      time.Time // zero for credentials that do not expire
 }
 
-// Callback used to retrieve V4 credentials, can be used with refreshable credentials
+// how long before their Expiration IsExpired reports credentials as expired, and caching
+// providers refresh them by default, so they are not used moments before the server would
+// start rejecting them, e.g. in the middle of a handshake
+const ExpiryMargin = 5 * time.Minute
+
+// reports whether the credentials expire within ExpiryMargin of now. credentials without an
+// Expiration, such as static long-term keys, never expire.
+func (c SigV4Credentials) IsExpired(now time.Time) bool {
+	return c.expiresWithin(now, ExpiryMargin)
+}
+
+// reports whether the credentials expire within margin of now
+func (c SigV4Credentials) expiresWithin(now time.Time, margin time.Duration) bool {
+	return !c.Expiration.IsZero() && !now.Before(c.Expiration.Add(-margin))
+}
+
+// returned by Challenge when the credentials to sign with have already expired,
+// so callers can refresh them rather than wait for the server to reject the signature.
+var ErrCredentialsExpired = errors.New("AWS credentials have expired")
+
+// returned by Challenge when temporary credentials come without their session token. the
+// server would reject the signature, as it does for any temporary key signed without one.
+var ErrMissingSessionToken = errors.New("AWS session token missing for temporary credentials")
+
+// access key id prefix of temporary credentials issued by STS, long-term keys start with AKIA
+const temporaryAccessKeyPrefix = "ASIA"
+
+// returned by Challenge and ExtractNonce when the server's challenge holds no nonce,
+// i.e. the challenge is malformed or was not sent by a SigV4 capable server.
+var ErrMissingNonce = internal.ErrMissingNonce
+
+// returned by Challenge when the server's challenge is empty, e.g. from a misbehaving server or
+// one not speaking the SigV4 protocol. it wraps ErrMissingNonce.
+var ErrEmptyChallenge = fmt.Errorf("empty challenge received from server: %w", ErrMissingNonce)
+
+// returned when no region is configured, as the credential scope cannot be signed without one
+var ErrRegionNotConfigured = errors.New("AWS region not configured")
+
+// light sanity check of region names such as us-west-2, cn-north-1 or eusc-de-east-1
+var regionPattern = regexp.MustCompile(`^[a-z]{2,}(-[a-z0-9]+)+-[0-9]+$`)
+
+// rejects regions that would produce an invalid credential scope, so misconfiguration
+// surfaces at construction rather than as a rejected handshake
+func validateRegion(region string) error {
+	if region == "" {
+		return fmt.Errorf("%w, set AWS_REGION or AWS_DEFAULT_REGION or pass a region", ErrRegionNotConfigured)
+	}
+	if !regionPattern.MatchString(region) {
+		return fmt.Errorf("AWS region %q is not well-formed, expected e.g. us-west-2", region)
+	}
+	return nil
+}
+
+// validates the region of an authenticator returned by the error-returning constructors
+func withValidRegion(auth AwsAuthenticator, err error) (AwsAuthenticator, error) {
+	if err != nil {
+		return auth, err
+	}
+	if err := validateRegion(auth.Region); err != nil {
+		return AwsAuthenticator{}, err
+	}
+	return auth, nil
+}
+
+// returned by Challenge and ExtractNonce when the server's nonce is empty or contains whitespace
+// or control characters, and by ExtractHexNonce when it is not hexadecimal
+var ErrMalformedNonce = internal.ErrMalformedNonce
+
+// Callback used to retrieve V4 credentials, can be used with refreshable credentials.
+// it is called from the goroutines of every connection being established, possibly at the
+// same time, so it must be safe for concurrent use.
 type SigV4CredentialsCallback func() (SigV4Credentials, error)
 
 // Authenticator for AWS Integration
 // these are exposed publicly to allow for easy initialization and go standard changing after the fact.
+// Challenge may be called concurrently, e.g. while gocql warms up its connection pool: each call
+// copies the fields into a per-connection authenticator and the only shared state, the signing
+// key cache and any caching provider, is synchronized. the fields must not be modified while
+// connections are being established, and the callbacks, providers, Logger and Tracer set on it
+// must be safe for concurrent use.
+// for the same reasons one authenticator can be shared by several gocql.ClusterConfig values,
+// e.g. clusters in the same region with different hosts or consistency settings.
 type AwsAuthenticator struct {
 	Region              string
 	AccessKeyId         string
 	SecretAccessKey     string
 	SessionToken        string
 	CredentialsCallback SigV4CredentialsCallback
-	currentTime         time.Time // this is mainly used for testing and not exposed
+	CredentialProvider  CredentialProvider       // takes precedence over CredentialsCallback when set
+	OnSuccess           func(data []byte)        // optional, receives the server's final SASL payload
+	VerifySuccess       func(data []byte) error  // optional, an error fails the connection despite the server's success
+	OnSigned            func(accessKeyId string) // optional, receives the access key id each challenge was signed with
+	NonceExtractor      NonceExtractor           // optional, defaults to parsing the standard nonce challenge
+	CredentialsTimeout  time.Duration            // bounds each credential retrieval, defaults to 10 seconds
+	ExpiresSeconds      int                      // signed X-Amz-Expires window, DefaultExpiresSeconds when not set
+	Service             string                   // signed service name, DefaultService when not set
+	Host                string                   // signed host header, DefaultHost when not set
+	ScopeOverride       string                   // signed verbatim instead of the computed credential scope, see WithScopeOverride
+	SignedHeaders       map[string]string        // signed in addition to the host header, see WithSignedHeaders
+	Clock               func() time.Time         // signing time source, defaults to time.Now().UTC()
+	ClockSkew           time.Duration            // added to the signing time, to compensate for a drifted host clock
+	Logger              Logger                   // optional, receives debug output of each signing step
+	Tracer              Tracer                   // optional, traces each challenge of the handshake
+	Observer            AuthObserver             // optional, receives the outcome of each handshake step
+	Metrics             Metrics                  // optional, counts signed challenges and failures
+	WipeSecrets         bool                     // zeroes secret copies and signing keys after signing, see WithWipeSecrets
+	OmitSessionToken    bool                     // signs without the session token, see WithOmitSessionToken
+
+	// set by the session based constructors. while the credential fields still hold the
+	// values loaded from the session, challenges re-fetch them from the session's credentials,
+	// which refresh themselves, so long-lived pools survive credential expiry. assigning
+	// different credential fields opts out of this.
+	sessionCredentials *credentials.Credentials
+	sessionSnapshot    SigV4Credentials
+
+	// set by WithSTSClient, read by the assume role constructors before they build the credentials
+	stsClient stsiface.STSAPI
+}
+
+// X-Amz-Expires signed when ExpiresSeconds is not set
+const DefaultExpiresSeconds = internal.DefaultExpiresSeconds
+
+// service name signed when Service is not set
+const DefaultService = internal.DefaultService
+
+// host header signed when Host is not set, the value Amazon Keyspaces expects
+const DefaultHost = internal.DefaultHost
+
+// used when CredentialsTimeout is not set
+const defaultCredentialsTimeout = 10 * time.Second
+
+// Parses the nonce out of the server's challenge payload
+type NonceExtractor func(req []byte) (string, error)
+
+// Receives debug output of the signing steps, e.g. to troubleshoot rejected handshakes.
+// messages never include the secret access key, session token or signature.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+}
+
+// Option applied by the constructors after the authenticator has been initialized
+type Option func(auth *AwsAuthenticator)
+
+// replaces the built-in nonce parsing, e.g. when a SASL proxy wraps the challenge frame
+func WithNonceExtractor(extractor NonceExtractor) Option {
+	return func(auth *AwsAuthenticator) {
+		auth.NonceExtractor = extractor
+	}
+}
+
+// sets OmitSessionToken, so responses carry no session_token even when the credentials have
+// one, e.g. to test signing with long-term keys while a token is set in the environment.
+// a diagnostic switch: the server rejects temporary credentials signed without their token.
+func WithOmitSessionToken() Option {
+	return func(auth *AwsAuthenticator) {
+		auth.OmitSessionToken = true
+	}
+}
+
+// sets WipeSecrets, as defense in depth against memory dumps. signing keys are then derived per
+// challenge instead of cached, and wiped along with the byte copy of the secret and the
+// intermediate HMAC results once the signature is computed. go strings cannot be wiped, so
+// SecretAccessKey and the strings returned by credential providers stay in memory until garbage
+// collected. retrieving credentials from a provider keeps the authenticator from holding the
+// secret between challenges.
+func WithWipeSecrets() Option {
+	return func(auth *AwsAuthenticator) {
+		auth.WipeSecrets = true
+	}
+}
+
+// reports the access key id each connection's challenge was signed with, e.g. for audit logs
+// to correlate with CloudTrail when credentials rotate or roles are assumed. the secret, session
+// token and signature are never passed. onSigned is called from the connecting goroutine.
+func WithOnSigned(onSigned func(accessKeyId string)) Option {
+	return func(auth *AwsAuthenticator) {
+		auth.OnSigned = onSigned
+	}
+}
+
+// checks the server's final payload, failing the connection when verify returns an error
+func WithVerifySuccess(verify func(data []byte) error) Option {
+	return func(auth *AwsAuthenticator) {
+		auth.VerifySuccess = verify
+	}
+}
+
+// logs the signing steps of every challenge to logger
+func WithLogger(logger Logger) Option {
+	return func(auth *AwsAuthenticator) {
+		auth.Logger = logger
+	}
+}
+
+// sets the region signed into the credential scope
+func WithRegion(region string) Option {
+	return func(auth *AwsAuthenticator) {
+		auth.Region = region
+	}
+}
+
+// signs with fixed credentials, the session token may be empty for long-term keys
+func WithStaticCredentials(accessKeyId string, secretAccessKey string, sessionToken string) Option {
+	return func(auth *AwsAuthenticator) {
+		auth.AccessKeyId = accessKeyId
+		auth.SecretAccessKey = secretAccessKey
+		auth.SessionToken = sessionToken
+	}
+}
+
+// retrieves credentials from callback on each challenge
+func WithCredentialsCallback(callback SigV4CredentialsCallback) Option {
+	return func(auth *AwsAuthenticator) {
+		auth.CredentialsCallback = callback
+	}
+}
+
+// retrieves credentials from provider on each challenge
+func WithCredentialProvider(provider CredentialProvider) Option {
+	return func(auth *AwsAuthenticator) {
+		auth.CredentialProvider = provider
+	}
+}
+
+// overrides the signed service name, DefaultService by default
+func WithService(service string) Option {
+	return func(auth *AwsAuthenticator) {
+		auth.Service = service
+	}
+}
+
+// overrides the signed host header, e.g. for a proxy or local mock that verifies a different
+// value. Amazon Keyspaces itself, including through VPC endpoints, expects DefaultHost.
+func WithHost(host string) Option {
+	return func(auth *AwsAuthenticator) {
+		auth.Host = host
+	}
+}
+
+// signs for the Amazon Keyspaces endpoint gocql connects to, e.g. the DNS name of a VPC
+// endpoint, by taking the region from it. the signed host header is left alone: Amazon
+// Keyspaces expects DefaultHost whichever endpoint is connected to, see WithHost. endpoints
+// that name no region, such as private DNS names or IP addresses, leave the region as
+// configured, see RegionFromKeyspacesHost.
+func WithEndpoint(endpoint string) Option {
+	return func(auth *AwsAuthenticator) {
+		if region, err := RegionFromKeyspacesHost(endpoint); err == nil {
+			auth.Region = region
+		}
+	}
+}
+
+// signs the given headers in addition to host, e.g. for a future protocol version or a proxy
+// verifying more of the request. names are lowercased and sorted into both the canonical
+// headers and the signed headers list, and a host entry is ignored, see WithHost. Amazon
+// Keyspaces today only verifies host. headers is copied, so later changes to it have no effect.
+func WithSignedHeaders(headers map[string]string) Option {
+	copied := copyHeaders(headers)
+	return func(auth *AwsAuthenticator) {
+		auth.SignedHeaders = copied
+	}
+}
+
+// signs with scope, e.g. 20200609/us-west-2/cassandra/aws4_request, instead of the credential
+// scope computed from the signing date, region and service. an escape hatch for staging or other
+// non-standard endpoints. the signing key is derived from the scope's parts, and a scope not of
+// the form date/region/service/aws4_request fails the challenge.
+func WithScopeOverride(scope string) Option {
+	return func(auth *AwsAuthenticator) {
+		auth.ScopeOverride = scope
+	}
+}
+
+// overrides the signing time source, e.g. for deterministic tests
+func WithClock(clock func() time.Time) Option {
+	return func(auth *AwsAuthenticator) {
+		auth.Clock = clock
+	}
 }
 
-// looks up AWS_DEFAULT_REGION, and falls back to AWS_REGION for Lambda compatibility
+// sets ClockSkew. a host whose clock runs 30 seconds behind the server's signs with
+// WithClockSkew(30 * time.Second), keeping X-Amz-Date inside the server's accepted window.
+func WithClockSkew(skew time.Duration) Option {
+	return func(auth *AwsAuthenticator) {
+		auth.ClockSkew = skew
+	}
+}
+
+func applyOptions(auth AwsAuthenticator, opts []Option) AwsAuthenticator {
+	for _, opt := range opts {
+		opt(&auth)
+	}
+	return auth
+}
+
+// looks up AWS_REGION, and falls back to AWS_DEFAULT_REGION, the same precedence as the AWS SDKs
+// and CLI, so the authenticator signs for the region the application's other clients use
 func getRegionEnvironment() string {
-	region := os.Getenv("AWS_DEFAULT_REGION")
+	region := os.Getenv("AWS_REGION")
 
 	if len(region) == 0 {
-		region = os.Getenv("AWS_REGION")
+		region = os.Getenv("AWS_DEFAULT_REGION")
 	}
 
 	return region
 }
 
+// initializes authenticator purely from options. the region defaults to the environment, as
+// for NewAwsAuthenticator, and when no option supplies credentials they are loaded from the AWS
+// SDK's default credential provider chain.
+func NewAwsAuthenticatorWithOptions(opts ...Option) AwsAuthenticator {
+	auth := applyOptions(AwsAuthenticator{Region: getRegionEnvironment()}, opts)
+	if auth.credentialProvider() == nil && auth.AccessKeyId == "" && auth.SecretAccessKey == "" {
+		return NewAwsAuthenticatorWithRegion(auth.Region, opts...)
+	}
+	return auth
+}
+
+// initializes authenticator with credentials already in hand, without an AWS SDK session or
+// credential lookup. the session token may be empty for long-term access keys.
+func NewAwsAuthenticatorStatic(region string, accessKeyId string, secretAccessKey string, sessionToken string, opts ...Option) AwsAuthenticator {
+	return applyOptions(AwsAuthenticator{}, append([]Option{
+		WithRegion(region),
+		WithStaticCredentials(accessKeyId, secretAccessKey, sessionToken)}, opts...))
+}
+
+// initializes authenticator purely from options like NewAwsAuthenticatorWithOptions, but
+// guaranteed to make no network calls, e.g. for hermetic tests in air-gapped CI. no AWS SDK
+// session is created and nothing is read from the environment: the region and static
+// credentials must both come from the options. fails right away when either is missing or
+// invalid, or when a credential provider or callback is set, instead of falling back to the
+// default credential provider chain, which may wait on IMDS.
+func NewAwsAuthenticatorOffline(opts ...Option) (AwsAuthenticator, error) {
+	auth := applyOptions(AwsAuthenticator{}, opts)
+	if auth.CredentialProvider != nil || auth.CredentialsCallback != nil {
+		return AwsAuthenticator{}, errors.New("offline authenticator only signs with static credentials, " +
+			"a credential provider or callback is set")
+	}
+	if err := validateRegion(auth.Region); err != nil {
+		return AwsAuthenticator{}, err
+	}
+	if err := validateCredentials(auth.staticCredentials(), time.Now()); err != nil {
+		return AwsAuthenticator{}, fmt.Errorf("offline authenticator requires static credentials: %w", err)
+	}
+	return auth, nil
+}
+
 // initializes authenticator with credentials loaded from AWS SDK's default credential provider chain.
 // region can be specified though environment variable or configuration.
-func NewAwsAuthenticator() AwsAuthenticator {
-	sess := session.Must(session.NewSession())
-	creds, _ := sess.Config.Credentials.Get()
-
-	return AwsAuthenticator{
-		Region:          getRegionEnvironment(),
-		AccessKeyId:     creds.AccessKeyID,
-		SecretAccessKey: creds.SecretAccessKey,
-		SessionToken:    creds.SessionToken}
+// panics if the session cannot be created, see NewAwsAuthenticatorE.
+func NewAwsAuthenticator(opts ...Option) AwsAuthenticator {
+	return NewAwsAuthenticatorWithRegion(getRegionEnvironment(), opts...)
 }
 
 // initializes authenticator with credentials loaded from AWS SDK's default credential provider chain.
 // region is accepted as an argument.
-func NewAwsAuthenticatorWithRegion(region string) AwsAuthenticator {
+// panics if the session cannot be created, see NewAwsAuthenticatorWithRegionE.
+func NewAwsAuthenticatorWithRegion(region string, opts ...Option) AwsAuthenticator {
 	sess := session.Must(session.NewSession())
-	creds, _ := sess.Config.Credentials.Get()
 
-	return AwsAuthenticator{
-		Region:          region,
-		AccessKeyId:     creds.AccessKeyID,
-		SecretAccessKey: creds.SecretAccessKey,
-		SessionToken:    creds.SessionToken}
+	auth, err := newAwsAuthenticatorFromSession(sess, region, opts)
+	if err != nil {
+		// kept for backward compatibility, missing credentials leave the credential fields empty.
+		// challenges keep asking the session, in case credentials become available later
+		auth = applyOptions(AwsAuthenticator{Region: region, sessionCredentials: sess.Config.Credentials}, opts)
+		warnMissingCredentials(auth, err)
+		return auth
+	}
+	// a provider may also succeed without returning any keys
+	if auth.AccessKeyId == "" && auth.CredentialProvider == nil && auth.CredentialsCallback == nil {
+		warnMissingCredentials(auth, errors.New("AWS credentials loaded from the default credential provider chain are empty"))
+	}
+	return auth
+}
+
+// reports missing credentials through the driver's logger, which writes to stderr by default,
+// and the authenticator's Logger when set, as they otherwise only surface as a rejected handshake
+func warnMissingCredentials(auth AwsAuthenticator, err error) {
+	message := fmt.Sprintf("sigv4: %v, Amazon Keyspaces will reject authentication "+
+		"(use NewAwsAuthenticatorWithRegionE to handle this error)", err)
+	gocql.Logger.Println(message)
+	if auth.Logger != nil {
+		auth.Logger.Debugf("%s", message)
+	}
+}
+
+// same as NewAwsAuthenticator, but returns session and credential errors instead of
+// panicking or leaving the credentials empty.
+func NewAwsAuthenticatorE(opts ...Option) (AwsAuthenticator, error) {
+	return NewAwsAuthenticatorWithRegionE(getRegionEnvironment(), opts...)
+}
+
+// same as NewAwsAuthenticatorWithRegion, but returns session and credential errors instead of
+// panicking or leaving the credentials empty.
+func NewAwsAuthenticatorWithRegionE(region string, opts ...Option) (AwsAuthenticator, error) {
+	sess, err := session.NewSession()
+	if err != nil {
+		return AwsAuthenticator{}, fmt.Errorf("failed to create AWS session: %w", err)
+	}
+	return withValidRegion(newAwsAuthenticatorFromSession(sess, region, opts))
+}
+
+// same as NewAwsAuthenticatorE, but when neither AWS_REGION nor AWS_DEFAULT_REGION is set the
+// region is discovered from the EC2 instance metadata service, so instance metadata is only
+// queried when opting in through this constructor. fails with ErrRegionNotConfigured when
+// discovery fails, e.g. off EC2 or with AWS_EC2_METADATA_DISABLED set.
+func NewAwsAuthenticatorAutoRegion(opts ...Option) (AwsAuthenticator, error) {
+	sess, err := session.NewSession()
+	if err != nil {
+		return AwsAuthenticator{}, fmt.Errorf("failed to create AWS session: %w", err)
+	}
+
+	region := getRegionEnvironment()
+	if region == "" {
+		if region, err = ec2metadata.New(sess).Region(); err != nil {
+			return AwsAuthenticator{}, fmt.Errorf("%w, and discovering it from EC2 instance metadata failed: %v",
+				ErrRegionNotConfigured, err)
+		}
+	}
+	return withValidRegion(newAwsAuthenticatorFromSession(sess, region, opts))
+}
+
+// initializes authenticator with the region and credentials of an existing session, so a
+// customized session (HTTP client, endpoints, shared config) is reused.
+func NewAwsAuthenticatorFromSession(sess *session.Session, opts ...Option) (AwsAuthenticator, error) {
+	return withValidRegion(newAwsAuthenticatorFromSession(sess, aws.StringValue(sess.Config.Region), opts))
+}
+
+// same as NewAwsAuthenticatorFromSession, but with the region accepted as an argument
+func NewAwsAuthenticatorFromSessionWithRegion(sess *session.Session, region string, opts ...Option) (AwsAuthenticator, error) {
+	return withValidRegion(newAwsAuthenticatorFromSession(sess, region, opts))
+}
+
+// initializes authenticator with credentials from the default credential provider chain, loaded
+// through a session created from cfg. this customizes how the providers that make network calls
+// (IMDS, STS, the container endpoint) reach AWS, e.g. cfg.HTTPClient for proxies, custom TLS or
+// tighter timeouts. an empty region uses the region of cfg or the environment.
+func NewAwsAuthenticatorWithConfig(region string, cfg *aws.Config, opts ...Option) (AwsAuthenticator, error) {
+	sess, err := session.NewSession(cfg)
+	if err != nil {
+		return AwsAuthenticator{}, fmt.Errorf("failed to create AWS session: %w", err)
+	}
+	if region == "" {
+		region = aws.StringValue(sess.Config.Region)
+	}
+	return withValidRegion(newAwsAuthenticatorFromSession(sess, region, opts))
+}
+
+// initializes authenticator with the credentials of the named profile in the shared credentials
+// and config files, without changing AWS_PROFILE. an empty region uses the profile's region.
+func NewAwsAuthenticatorWithProfile(region string, profile string, opts ...Option) (AwsAuthenticator, error) {
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Profile:           profile,
+		SharedConfigState: session.SharedConfigEnable})
+	if err != nil {
+		return AwsAuthenticator{}, fmt.Errorf("failed to create AWS session: %w", err)
+	}
+	if region == "" {
+		region = aws.StringValue(sess.Config.Region)
+	}
+	return withValidRegion(newAwsAuthenticatorFromSession(sess, region, opts))
+}
+
+// same as NewAwsAuthenticatorWithProfile, reading the shared credentials and config files from
+// the given paths instead of AWS_SHARED_CREDENTIALS_FILE, AWS_CONFIG_FILE or ~/.aws, e.g. files
+// mounted into a container. either path may be empty to load only the other file, and an
+// empty profile selects the default profile.
+func NewAwsAuthenticatorFromFiles(region string, credentialsFile string, configFile string, profile string, opts ...Option) (AwsAuthenticator, error) {
+	// later files take precedence, the credentials file as with the default locations
+	files := []string{}
+	for _, file := range []string{configFile, credentialsFile} {
+		if file != "" {
+			files = append(files, file)
+		}
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Profile:           profile,
+		SharedConfigFiles: files,
+		SharedConfigState: session.SharedConfigEnable})
+	if err != nil {
+		return AwsAuthenticator{}, fmt.Errorf("failed to create AWS session: %w", err)
+	}
+	if region == "" {
+		region = aws.StringValue(sess.Config.Region)
+	}
+	return withValidRegion(newAwsAuthenticatorFromSession(sess, region, opts))
+}
+
+// initializes authenticator with credentials currently provided by the session
+func newAwsAuthenticatorFromSession(sess *session.Session, region string, opts []Option) (AwsAuthenticator, error) {
+	creds, err := sess.Config.Credentials.Get()
+	if err != nil {
+		return AwsAuthenticator{}, fmt.Errorf("failed to retrieve AWS credentials: %w", err)
+	}
+
+	// values read from files or environment variables commonly carry a trailing newline
+	auth := AwsAuthenticator{
+		Region:             region,
+		AccessKeyId:        strings.TrimSpace(creds.AccessKeyID),
+		SecretAccessKey:    strings.TrimSpace(creds.SecretAccessKey),
+		SessionToken:       strings.TrimSpace(creds.SessionToken),
+		sessionCredentials: sess.Config.Credentials}
+	auth.sessionSnapshot = auth.staticCredentials()
+	return applyOptions(auth, opts), nil
 }
 
 // initializes authenticator with the provided region and credentials callback
-func NewAwsAuthenticatorWithCredentialCallback(region string, callback SigV4CredentialsCallback) AwsAuthenticator {
-	return AwsAuthenticator{
+func NewAwsAuthenticatorWithCredentialCallback(region string, callback SigV4CredentialsCallback, opts ...Option) AwsAuthenticator {
+	return applyOptions(AwsAuthenticator{
 		Region:              region,
-		CredentialsCallback: callback}
+		CredentialsCallback: callback}, opts)
+}
+
+// initializes authenticator with the provided region and context-aware credentials callback.
+// a nil callback leaves CredentialProvider unset, as NewAwsAuthenticatorWithCredentialCallback does.
+func NewAwsAuthenticatorWithCredentialCallbackCtx(region string, callback SigV4CredentialsCallbackCtx, opts ...Option) AwsAuthenticator {
+	if callback == nil {
+		return NewAwsAuthenticatorWithCredentialProvider(region, nil, opts...)
+	}
+	return NewAwsAuthenticatorWithCredentialProvider(region, callback, opts...)
+}
+
+// initializes authenticator with the provided region and credential provider
+func NewAwsAuthenticatorWithCredentialProvider(region string, provider CredentialProvider, opts ...Option) AwsAuthenticator {
+	return applyOptions(AwsAuthenticator{
+		Region:             region,
+		CredentialProvider: provider}, opts)
+}
+
+// resolves the provider to consult on each challenge, nil when static credentials are used
+func (p AwsAuthenticator) credentialProvider() CredentialProvider {
+	if p.CredentialProvider != nil {
+		return p.CredentialProvider
+	}
+	if p.CredentialsCallback != nil {
+		return p.CredentialsCallback
+	}
+	if p.sessionCredentials != nil && p.sessionSnapshot == p.staticCredentials() {
+		return sdkCredentialProvider{p.sessionCredentials}
+	}
+	return nil
+}
+
+// releases background resources held by the credential source, such as the goroutine of
+// NewBackgroundRefreshingCredentialProvider, by closing the provider when it implements
+// io.Closer. safe to call when there is nothing to release, and more than once. the stop
+// func of NewBackgroundRefreshingCallback is not reachable from here and must be called by
+// its owner. call this after closing the gocql session, as new connections still sign.
+func (p AwsAuthenticator) Close() error {
+	if closer, ok := p.credentialProvider().(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// the provider signing challenges depend on: the resolved provider, or the static credential
+// fields adapted into one, so every credential source reaches the signing path the same way
+func (p AwsAuthenticator) signingCredentialProvider() CredentialProvider {
+	if provider := p.credentialProvider(); provider != nil {
+		return provider
+	}
+	return staticCredentialProvider(p.staticCredentials())
 }
 
+func (p AwsAuthenticator) staticCredentials() SigV4Credentials {
+	return SigV4Credentials{
+		AccessKeyId:     p.AccessKeyId,
+		SecretAccessKey: p.SecretAccessKey,
+		SessionToken:    p.SessionToken}
+}
+
+// protocol parameters used to sign this authenticator's challenges. SignedHeaders is copied, as
+// the map would otherwise be shared with every connection of every cluster using this authenticator.
+func (p AwsAuthenticator) signer() internal.Signer {
+	return internal.Signer{
+		ExpiresSeconds: p.ExpiresSeconds,
+		Service:        p.Service,
+		Host:           p.Host,
+		ScopeOverride:  p.ScopeOverride,
+		SignedHeaders:  copyHeaders(p.SignedHeaders)}
+}
+
+func copyHeaders(headers map[string]string) map[string]string {
+	if headers == nil {
+		return nil
+	}
+	copied := make(map[string]string, len(headers))
+	for name, value := range headers {
+		copied[name] = value
+	}
+	return copied
+}
+
+// initial SASL response selecting the SigV4 mechanism: the mechanism name followed by two NUL
+// bytes, laid out like a SASL PLAIN response with empty fields. Amazon Keyspaces only answers
+// with a nonce challenge for exactly these 7 bytes, so the padding must not be dropped.
+const initialResponse = "SigV4\000\000"
+
+// returned by Challenge when the server announces an authenticator that cannot accept SigV4,
+// e.g. a self-managed Cassandra cluster using PasswordAuthenticator
+var ErrUnsupportedAuthenticator = errors.New("server authenticator does not support SigV4")
+
+// authenticator classes of servers known to only accept username and password. any other class,
+// including none and the one Amazon Keyspaces announces, is answered with SigV4.
+var passwordAuthenticators = []string{
+	"org.apache.cassandra.auth.PasswordAuthenticator",
+	"com.instaclustr.cassandra.auth.SharedSecretAuthenticator",
+	"com.datastax.bdp.cassandra.auth.DseAuthenticator",
+	"io.aiven.cassandra.auth.AivenAuthenticator",
+	"com.ericsson.bss.cassandra.ecaudit.auth.AuditPasswordAuthenticator",
+	"com.ericsson.bss.cassandra.ecaudit.auth.AuditAuthenticator",
+}
+
+// gocql passes the authenticator class announced by the server as req
 func (p AwsAuthenticator) Challenge(req []byte) ([]byte, gocql.Authenticator, error) {
-	var resp []byte = []byte("SigV4\000\000")
-
-	// copy these rather than use a reference due to how gocql creates connections (it's just
-	// safer if everything is a fresh copy).
-	auth := signingAuthenticator{region: p.Region,
-		accessKeyId:         p.AccessKeyId,
-		secretAccessKey:     p.SecretAccessKey,
-		sessionToken:        p.SessionToken,
-		credentialsCallback: p.CredentialsCallback,
-		currentTime:         p.currentTime}
+	end := startSpan(p.Tracer, "sigv4.initial_response", p.Region, p.credentialSource())
+	observe := startObservation(p.Observer, AuthEventInitialResponse, p.Region)
+	for _, class := range passwordAuthenticators {
+		if string(req) == class {
+			err := fmt.Errorf("%w: %s expects a username and password, "+
+				"the SigV4 authenticator only works with Amazon Keyspaces", ErrUnsupportedAuthenticator, class)
+			end(err)
+			observe("", err)
+			return nil, nil, err
+		}
+	}
+	resp := []byte(initialResponse)
+
+	auth := p.signingAuthenticator()
+	end(nil)
+	observe("", nil)
 	return resp, auth, nil
 }
 
+// copy these rather than use a reference due to how gocql creates connections (it's just
+// safer if everything is a fresh copy).
+func (p AwsAuthenticator) signingAuthenticator() signingAuthenticator {
+	return signingAuthenticator{region: p.Region,
+		credentialProvider: p.signingCredentialProvider(),
+		onSuccess:          p.OnSuccess,
+		verifySuccess:      p.VerifySuccess,
+		onSigned:           p.OnSigned,
+		nonceExtractor:     p.NonceExtractor,
+		credentialsTimeout: p.CredentialsTimeout,
+		signer:             p.signer(),
+		clock:              p.Clock,
+		clockSkew:          p.ClockSkew,
+		logger:             p.Logger,
+		tracer:             p.Tracer,
+		observer:           p.Observer,
+		metrics:            p.Metrics,
+		credentialSource:   p.credentialSource(),
+		wipeSecrets:        p.WipeSecrets,
+		omitSessionToken:   p.OmitSessionToken}
+}
+
+// computes the response this authenticator would send for the given nonce at time t, without a
+// live connection. credentials are resolved exactly as during a handshake, so the output can be
+// compared against what the server received when diagnosing authentication failures.
+func (p AwsAuthenticator) SignChallenge(nonce string, t time.Time) (string, error) {
+	return p.signingAuthenticator().sign(nonce, t)
+}
+
+// computes the exact bytes this authenticator would answer the server's challenge with, e.g.
+// for custom SASL plumbing: the nonce is extracted and the credentials are resolved as during a
+// handshake, and the nonce is signed at the current time of Clock. unlike a handshake, nothing
+// is reported to Metrics, Tracer, Observer or OnSigned.
+func (p AwsAuthenticator) SignResponse(challenge []byte) ([]byte, error) {
+	auth := p.signingAuthenticator()
+	nonce, err := auth.extractNonce(challenge)
+	if err != nil {
+		return nil, err
+	}
+	signedResponse, err := auth.sign(nonce, auth.signingTime())
+	if err != nil {
+		return nil, err
+	}
+	return []byte(signedResponse), nil
+}
+
+// nonce signed by Validate in place of the server's
+const validationNonce = "00000000000000000000000000000000"
+
+// pre-flight check that the authenticator can sign a challenge, without connecting: credentials
+// are retrieved from the provider or callback, if any, and checked for presence and expiry, the
+// region must be a region name, and a dummy nonce is signed. returns the error a handshake would
+// fail with.
+func (p AwsAuthenticator) Validate() error {
+	if err := validateRegion(p.Region); err != nil {
+		return err
+	}
+	auth := p.signingAuthenticator()
+	_, err := auth.sign(validationNonce, auth.signingTime())
+	return err
+}
+
+// extracts the nonce from a raw server challenge, as done during a handshake.
+// the result can be passed to SignChallenge.
+func ExtractNonce(challenge []byte) (string, error) {
+	return internal.ExtractNonce(challenge)
+}
+
+// same as ExtractNonce, but rejects nonces that are not hexadecimal, the format Amazon Keyspaces
+// sends today. opt in with WithNonceExtractor(ExtractHexNonce) to fail early on unexpected frames.
+func ExtractHexNonce(challenge []byte) (string, error) {
+	return internal.ExtractHexNonce(challenge)
+}
+
 func (p AwsAuthenticator) Success(data []byte) error {
+	return handleSuccess(data, p.OnSuccess, p.VerifySuccess)
+}
+
+// reports the server's final payload to the hooks. Amazon Keyspaces sends no payload today,
+// so the payload is only interpreted by verifySuccess, e.g. for a proxy or a future server that
+// reports diagnostics there. its error is returned to gocql, which then closes the connection.
+func handleSuccess(data []byte, onSuccess func(data []byte), verifySuccess func(data []byte) error) error {
+	if onSuccess != nil {
+		onSuccess(data)
+	}
+	if verifySuccess != nil {
+		if err := verifySuccess(data); err != nil {
+			return fmt.Errorf("authentication success rejected: %w", err)
+		}
+	}
 	return nil
 }
 
+// rejects credentials that can never produce a signature the server accepts at time t.
+// whitespace usually comes from a credential file or variable read without trimming.
+func validateCredentials(credentials SigV4Credentials, t time.Time) error {
+	if credentials.expiresWithin(t, 0) {
+		expiration := credentials.Expiration.UTC().Format(time.RFC3339)
+		// temporary credentials, whose session token is what the server would reject
+		if credentials.SessionToken != "" {
+			return fmt.Errorf("%w, session token expired at %s", ErrCredentialsExpired, expiration)
+		}
+		return fmt.Errorf("%w at %s", ErrCredentialsExpired, expiration)
+	}
+
+	// commonly the default chain found no credentials at all
+	if credentials.AccessKeyId == "" {
+		return errors.New("AWS access key id is empty")
+	}
+	if credentials.SecretAccessKey == "" {
+		return errors.New("AWS secret access key is empty")
+	}
+	// commonly only the key pair of temporary credentials was copied into the configuration
+	if credentials.SessionToken == "" && strings.HasPrefix(credentials.AccessKeyId, temporaryAccessKeyPrefix) {
+		return fmt.Errorf("%w, access key ids starting with %s are temporary", ErrMissingSessionToken, temporaryAccessKeyPrefix)
+	}
+
+	fields := []struct {
+		name  string
+		value string
+	}{
+		{"AWS access key id", credentials.AccessKeyId},
+		{"AWS secret access key", credentials.SecretAccessKey},
+		{"AWS session token", credentials.SessionToken},
+	}
+	for _, field := range fields {
+		if strings.IndexFunc(field.value, unicode.IsSpace) >= 0 {
+			return fmt.Errorf("%s contains whitespace", field.name)
+		}
+	}
+	return nil
+}
+
+// computes the signed response for an already extracted nonce. every input is explicit, so
+// the result is deterministic and suitable for golden snapshot tests of the auth bytes.
+func SignFixed(region string, nonce string, creds SigV4Credentials, t time.Time) (string, error) {
+	if err := validateCredentials(creds, t); err != nil {
+		return "", err
+	}
+	return internal.BuildSignedResponse(region, nonce, creds.AccessKeyId, creds.SecretAccessKey, creds.SessionToken, t), nil
+}
+
 // this is the internal private authenticator we actually use
 type signingAuthenticator struct {
-	region              string
-	accessKeyId         string
-	secretAccessKey     string
-	sessionToken        string
-	credentialsCallback SigV4CredentialsCallback
-	currentTime         time.Time
+	region             string
+	credentialProvider CredentialProvider // never nil, static credentials are adapted
+	onSuccess          func(data []byte)
+	verifySuccess      func(data []byte) error
+	onSigned           func(accessKeyId string)
+	nonceExtractor     NonceExtractor
+	credentialsTimeout time.Duration
+	signer             internal.Signer
+	clock              func() time.Time
+	clockSkew          time.Duration
+	logger             Logger
+	tracer             Tracer
+	observer           AuthObserver
+	metrics            Metrics
+	credentialSource   string
+	wipeSecrets        bool
+	omitSessionToken   bool
 }
 
 func (p signingAuthenticator) Challenge(req []byte) ([]byte, gocql.Authenticator, error) {
-	nonce, err := internal.ExtractNonce(req)
+	end := startSpan(p.tracer, "sigv4.challenge", p.region, p.credentialSource)
+	observe := startObservation(p.observer, AuthEventChallenge, p.region)
+	resp, accessKeyId, err := p.challenge(req)
+	end(err)
+	observe(accessKeyId, err)
 	if err != nil {
 		return nil, nil, err
 	}
+	// gocql only reports success to the authenticator returned by the last challenge
+	return resp, p, nil
+}
 
-	// init the time if not provided.
-	var t time.Time = p.currentTime
-	if t.IsZero() {
-		t = time.Now().UTC()
+// signs the challenge, returning the response and the access key id signed with
+func (p signingAuthenticator) challenge(req []byte) ([]byte, string, error) {
+	nonce, err := p.extractNonce(req)
+	if err != nil {
+		p.debugf("sigv4: failed to extract nonce: %v", err)
+		p.inc(MetricNonceExtractionFailures)
+		return nil, "", err
 	}
+	p.debugf("sigv4: extracted nonce %s", nonce)
 
-	accessKeyId := p.accessKeyId
-	secretAccessKey := p.secretAccessKey
-	sessionToken := p.sessionToken
-	if p.credentialsCallback != nil {
-		credentials, err := p.credentialsCallback()
-		if err != nil {
-			return nil, nil, fmt.Errorf("failed to retrieve AWS credentials: %w", err)
-		}
-		accessKeyId = credentials.AccessKeyId
-		secretAccessKey = credentials.SecretAccessKey
-		sessionToken = credentials.SessionToken
+	signedResponse, accessKeyId, err := p.signResponse(nonce, p.signingTime())
+	if err != nil {
+		return nil, "", err
+	}
+	p.inc(MetricChallengesSigned)
+	if p.onSigned != nil {
+		p.onSigned(accessKeyId)
 	}
-
-	signedResponse := internal.BuildSignedResponse(p.region, nonce, accessKeyId,
-		secretAccessKey, sessionToken, t)
 
 	// copy this to a sepearte byte array to prevent some slicing corruption with how the framer object works
 	resp := make([]byte, len(signedResponse))
 	copy(resp, []byte(signedResponse))
+	return resp, accessKeyId, nil
+}
 
-	return resp, nil, nil
+// parses the nonce with the configured extractor, or the standard one
+func (p signingAuthenticator) extractNonce(req []byte) (string, error) {
+	if len(req) == 0 {
+		return "", ErrEmptyChallenge
+	}
+	if p.nonceExtractor != nil {
+		return p.nonceExtractor(req)
+	}
+	return internal.ExtractNonce(req)
+}
+
+// init the time if no clock is provided. a clock in another zone is normalized to UTC,
+// which the date stamp, scope and X-Amz-Date are all expressed in.
+func (p signingAuthenticator) signingTime() time.Time {
+	var t time.Time
+	if p.clock != nil {
+		t = p.clock().UTC()
+	} else {
+		t = time.Now().UTC()
+	}
+	return t.Add(p.clockSkew)
+}
+
+// resolves the credentials and signs the nonce at time t
+func (p signingAuthenticator) sign(nonce string, t time.Time) (string, error) {
+	signedResponse, _, err := p.signResponse(nonce, t)
+	return signedResponse, err
+}
+
+// same as sign, also returning the access key id signed with
+func (p signingAuthenticator) signResponse(nonce string, t time.Time) (string, string, error) {
+	credentials, err := p.credentials()
+	if err != nil {
+		return "", "", err
+	}
+
+	err = validateCredentials(credentials, t)
+	// a provider handing out expired credentials, e.g. a callback without its own expiry
+	// tracking, gets one chance to refresh them before the handshake fails
+	_, static := p.credentialProvider.(staticCredentialProvider)
+	if errors.Is(err, ErrCredentialsExpired) && !static {
+		p.debugf("sigv4: %v, retrieving credentials again", err)
+		if credentials, err = p.credentials(); err != nil {
+			return "", "", err
+		}
+		err = validateCredentials(credentials, t)
+	}
+	if err != nil {
+		return "", "", err
+	}
+	// the format is not checked here, so tests and mocks can use any region name
+	if p.region == "" {
+		return "", "", ErrRegionNotConfigured
+	}
+	if p.signer.ScopeOverride != "" {
+		if err := internal.ValidateScope(p.signer.ScopeOverride); err != nil {
+			return "", "", err
+		}
+	}
+
+	if p.omitSessionToken && credentials.SessionToken != "" {
+		p.debugf("sigv4: omitting the session token of access key %s", credentials.AccessKeyId)
+		credentials.SessionToken = ""
+	}
+
+	p.debugf("sigv4: signing for access key %s with scope %s at %s", credentials.AccessKeyId,
+		p.signer.Scope(t, p.region), t.Format(time.RFC3339))
+	if p.wipeSecrets {
+		secret := []byte(credentials.SecretAccessKey)
+		defer internal.Wipe(secret)
+		return p.signer.BuildSignedResponseBytes(p.region, nonce, credentials.AccessKeyId,
+			secret, credentials.SessionToken, t), credentials.AccessKeyId, nil
+	}
+	return p.signer.BuildSignedResponse(p.region, nonce, credentials.AccessKeyId,
+		credentials.SecretAccessKey, credentials.SessionToken, t), credentials.AccessKeyId, nil
+}
+
+// the static credentials, or those retrieved from the provider when one is set
+func (p signingAuthenticator) credentials() (SigV4Credentials, error) {
+	// nothing is retrieved, so static credentials are neither timed nor counted. they always
+	// hit the signing key cache after the first challenge of the day, see BenchmarkStaticChallenge
+	if static, ok := p.credentialProvider.(staticCredentialProvider); ok {
+		return SigV4Credentials(static), nil
+	}
+
+	timeout := p.credentialsTimeout
+	if timeout <= 0 {
+		timeout = defaultCredentialsTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	p.inc(MetricCredentialRetrievals)
+	credentials, err := p.credentialProvider.Retrieve(ctx)
+	if err != nil {
+		p.inc(MetricCredentialRetrievalFailures)
+		p.debugf("sigv4: failed to retrieve credentials: %v", err)
+		return SigV4Credentials{}, fmt.Errorf("failed to retrieve AWS credentials: %w", err)
+	}
+	p.debugf("sigv4: retrieved credentials for access key %s from provider", credentials.AccessKeyId)
+	return credentials, nil
+}
+
+func (p signingAuthenticator) debugf(format string, args ...interface{}) {
+	if p.logger != nil {
+		p.logger.Debugf(format, args...)
+	}
 }
 
 func (p signingAuthenticator) Success(data []byte) error {
-	return nil
+	observe := startObservation(p.observer, AuthEventSuccess, p.region)
+	err := handleSuccess(data, p.onSuccess, p.verifySuccess)
+	observe("", err)
+	return err
 }
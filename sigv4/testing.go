@@ -0,0 +1,28 @@
+/*
+ *  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package sigv4
+
+import "time"
+
+// initializes authenticator for unit tests of code built on this plugin: it signs with the given
+// static credentials at fixedTime, so signatures are fully deterministic and can be asserted
+// exactly, with no AWS credentials or endpoint involved. not meant for production use, where
+// a fixed time makes the server reject signatures once they are older than ExpiresSeconds.
+func NewStaticAuthenticatorForTesting(region string, accessKeyId string, secretAccessKey string, sessionToken string, fixedTime time.Time) AwsAuthenticator {
+	return NewAwsAuthenticatorStatic(region, accessKeyId, secretAccessKey, sessionToken,
+		WithClock(func() time.Time { return fixedTime }))
+}
@@ -0,0 +1,35 @@
+/*
+ *  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package sigv4
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewStaticAuthenticatorForTesting(t *testing.T) {
+	target := NewStaticAuthenticatorForTesting("us-west-2", "UserID-1", "UserSecretKey-1", "sess-token-1", stdClock())
+
+	for i := 0; i < 2; i++ {
+		_, challenger, _ := target.Challenge(nil)
+		resp, _, err := challenger.Challenge(stdNonce)
+		assert.NoError(t, err)
+		expected := "signature=7f3691c18a81b8ce7457699effbfae5b09b4e0714ab38c1292dbdf082c9ddd87,access_key=UserID-1,amzdate=2020-06-09T22:41:51.000Z,session_token=sess-token-1"
+		assert.Equal(t, expected, string(resp))
+	}
+}
@@ -0,0 +1,55 @@
+/*
+ *  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package sigv4
+
+// Starts a span named name with the given attributes, returning the function that ends it
+// with the outcome of the traced step. a few lines adapt an OpenTelemetry trace.Tracer, see
+// the README. attribute values never hold secret material.
+type Tracer interface {
+	StartSpan(name string, attributes map[string]string) (end func(err error))
+}
+
+// traces each challenge of the handshake with tracer
+func WithTracer(tracer Tracer) Option {
+	return func(auth *AwsAuthenticator) {
+		auth.Tracer = tracer
+	}
+}
+
+// where challenges take their credentials from, reported as the sigv4.credential_source attribute
+func (p AwsAuthenticator) credentialSource() string {
+	switch {
+	case p.CredentialProvider != nil:
+		return "provider"
+	case p.CredentialsCallback != nil:
+		return "callback"
+	case p.credentialProvider() != nil:
+		return "session"
+	default:
+		return "static"
+	}
+}
+
+// starts a span for a handshake step, a no-op when no tracer is set
+func startSpan(tracer Tracer, name string, region string, credentialSource string) func(err error) {
+	if tracer == nil {
+		return func(error) {}
+	}
+	return tracer.StartSpan(name, map[string]string{
+		"sigv4.region":            region,
+		"sigv4.credential_source": credentialSource})
+}
@@ -0,0 +1,80 @@
+/*
+ *  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package sigv4
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recordedSpan struct {
+	name       string
+	attributes map[string]string
+	err        error
+	ended      bool
+}
+
+type recordingTracer struct {
+	spans []*recordedSpan
+}
+
+func (t *recordingTracer) StartSpan(name string, attributes map[string]string) func(err error) {
+	span := &recordedSpan{name: name, attributes: attributes}
+	t.spans = append(t.spans, span)
+	return func(err error) {
+		span.err = err
+		span.ended = true
+	}
+}
+
+func TestTracer(t *testing.T) {
+	tracer := &recordingTracer{}
+	target := buildStdTarget()
+	WithTracer(tracer)(target)
+
+	_, challenger, _ := target.Challenge(nil)
+	_, _, err := challenger.Challenge(stdNonce)
+	assert.NoError(t, err)
+
+	assert.Len(t, tracer.spans, 2)
+	assert.Equal(t, "sigv4.initial_response", tracer.spans[0].name)
+	assert.Equal(t, "sigv4.challenge", tracer.spans[1].name)
+	for _, span := range tracer.spans {
+		assert.True(t, span.ended)
+		assert.NoError(t, span.err)
+		assert.Equal(t, map[string]string{
+			"sigv4.region":            "us-west-2",
+			"sigv4.credential_source": "static"}, span.attributes)
+	}
+}
+
+func TestTracerRecordsFailure(t *testing.T) {
+	tracer := &recordingTracer{}
+	target := NewAwsAuthenticatorWithCredentialCallback("us-west-2", func() (SigV4Credentials, error) {
+		return SigV4Credentials{}, errors.New("bad error")
+	}, WithTracer(tracer))
+
+	_, challenger, _ := target.Challenge(nil)
+	_, _, err := challenger.Challenge(stdNonce)
+	assert.Error(t, err)
+
+	assert.Len(t, tracer.spans, 2)
+	assert.Equal(t, "callback", tracer.spans[1].attributes["sigv4.credential_source"])
+	assert.Equal(t, err, tracer.spans[1].err)
+}
@@ -0,0 +1,59 @@
+/*
+ *  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package sigv4
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sigv4-auth-cassandra-gocql-driver-plugin/sigv4/internal"
+)
+
+// checks a signed response the way the server would, for harnesses mimicking Amazon Keyspaces:
+// the signature is re-derived from the response's access key, the nonce and secret, assuming
+// the default service, host and expiry. it reports false when the signature or the amzdate,
+// compared against t, does not match, and an error when the response is malformed.
+func VerifySignedResponse(region string, nonce string, secret string, response string, t time.Time) (bool, error) {
+	fields := map[string]string{}
+	for _, param := range strings.Split(response, ",") {
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) != 2 {
+			return false, fmt.Errorf("malformed signed response parameter %q", param)
+		}
+		fields[kv[0]] = kv[1]
+	}
+	for _, name := range []string{"signature", "access_key", "amzdate"} {
+		if fields[name] == "" {
+			return false, fmt.Errorf("signed response is missing %s", name)
+		}
+	}
+	if fields["amzdate"] != t.UTC().Format(internal.AmzDateFormat) {
+		return false, nil
+	}
+
+	expected := internal.Signer{}.Signature(region, nonce, fields["access_key"], secret, t.UTC())
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(fields["signature"])) == 1, nil
+}
+
+// returns the credential scope and canonical request the authenticator signs for a challenge,
+// assuming the default service, host and expiry. no secret is needed, so they can be logged and
+// compared against the AWS SigV4 reference implementation when a signature is rejected.
+func DescribeSigning(region string, nonce string, accessKeyId string, t time.Time) (scope string, canonicalRequest string) {
+	return internal.Signer{}.DescribeSigning(region, nonce, accessKeyId, t)
+}
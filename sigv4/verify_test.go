@@ -0,0 +1,74 @@
+/*
+ *  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package sigv4
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifySignedResponse(t *testing.T) {
+	target := NewStaticAuthenticatorForTesting("us-west-2", "UserID-1", "UserSecretKey-1", "sess-token-1", stdClock())
+	_, challenger, _ := target.Challenge(nil)
+	resp, _, _ := challenger.Challenge(stdNonce)
+
+	ok, err := VerifySignedResponse("us-west-2", "91703fdc2ef562e19fbdab0f58e42fe5", "UserSecretKey-1", string(resp), stdClock())
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, _ = VerifySignedResponse("us-west-2", "91703fdc2ef562e19fbdab0f58e42fe5", "OtherSecret", string(resp), stdClock())
+	assert.False(t, ok)
+	ok, _ = VerifySignedResponse("us-east-1", "91703fdc2ef562e19fbdab0f58e42fe5", "UserSecretKey-1", string(resp), stdClock())
+	assert.False(t, ok)
+	ok, _ = VerifySignedResponse("us-west-2", "other-nonce", "UserSecretKey-1", string(resp), stdClock())
+	assert.False(t, ok)
+	ok, _ = VerifySignedResponse("us-west-2", "91703fdc2ef562e19fbdab0f58e42fe5", "UserSecretKey-1", string(resp), stdClock().Add(time.Second))
+	assert.False(t, ok)
+}
+
+func TestVerifySignedResponseMalformed(t *testing.T) {
+	_, err := VerifySignedResponse("us-west-2", "nonce", "secret", "garbage", stdClock())
+	assert.EqualError(t, err, `malformed signed response parameter "garbage"`)
+
+	_, err = VerifySignedResponse("us-west-2", "nonce", "secret", "signature=abc,amzdate=2020-06-09T22:41:51.000Z", stdClock())
+	assert.EqualError(t, err, "signed response is missing access_key")
+}
+
+func TestDescribeSigning(t *testing.T) {
+	scope, canonicalRequest := DescribeSigning("us-west-2", "91703fdc2ef562e19fbdab0f58e42fe5", "UserID-1", stdClock())
+
+	assert.Equal(t, "20200609/us-west-2/cassandra/aws4_request", scope)
+	assert.Equal(t, "PUT\n"+
+		"/authenticate\n"+
+		"X-Amz-Algorithm=AWS4-HMAC-SHA256&X-Amz-Credential=UserID-1%2F20200609%2Fus-west-2%2Fcassandra%2Faws4_request&X-Amz-Date=2020-06-09T22%3A41%3A51.000Z&X-Amz-Expires=900\n"+
+		"host:cassandra\n\n"+
+		"host\n"+
+		"ddf250111597b3f35e51e649f59e3f8b30ff5b247166d709dc1b1e60bd927070", canonicalRequest)
+}
+
+func TestVerifySignedResponseWithSTSSessionToken(t *testing.T) {
+	target := NewStaticAuthenticatorForTesting("us-west-2", "UserID-1", "UserSecretKey-1", "FwoGZXIvYXdzEJr//////////wEa+/kP9m==", stdClock())
+	_, challenger, _ := target.Challenge(nil)
+	resp, _, _ := challenger.Challenge(stdNonce)
+
+	assert.Contains(t, string(resp), ",session_token=FwoGZXIvYXdzEJr//////////wEa+/kP9m==")
+	ok, err := VerifySignedResponse("us-west-2", "91703fdc2ef562e19fbdab0f58e42fe5", "UserSecretKey-1", string(resp), stdClock())
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}